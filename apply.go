@@ -1,10 +1,23 @@
 package workflow
 
-import "time"
+import (
+	"net/http"
+	"time"
+)
 
 type options struct {
-	info      Info
-	callbacks []Callback
+	info          Info
+	callbacks     []Callback
+	hub           *EventHub
+	phaseRetry    []RetryOption
+	interventions *InterventionQueue
+	barrier       BarrierStore
+	client        *http.Client
+	coordinator   *CoordinatorClient
+	expiryWheel   *timeWheel
+	expiryTimeout time.Duration
+	labels        map[string]string
+	meta          Meta
 }
 
 type Option interface {
@@ -35,6 +48,74 @@ func (i infoOption) apply(opts *options) {
 	opts.info = i.info
 }
 
+// WithEventHub publish the state transitions and errors of the info to hub
+func WithEventHub(hub *EventHub) Option {
+	return eventHubOption{hub}
+}
+
+type eventHubOption struct {
+	hub *EventHub
+}
+
+func (e eventHubOption) apply(opts *options) {
+	opts.hub = e.hub
+}
+
+// WithPhaseRetry retries the Confirm and Cancel phases of a TCC with backoff,
+// until they succeed or the attempts of opts are exhausted
+func WithPhaseRetry(opts ...RetryOption) Option {
+	return phaseRetryOption{opts}
+}
+
+type phaseRetryOption struct {
+	opts []RetryOption
+}
+
+func (p phaseRetryOption) apply(opts *options) {
+	opts.phaseRetry = append([]RetryOption{}, p.opts...)
+}
+
+// WithInterventionQueue parks the Confirm and Cancel phases of a TCC that still fail after their retries
+func WithInterventionQueue(queue *InterventionQueue) Option {
+	return interventionQueueOption{queue}
+}
+
+type interventionQueueOption struct {
+	queue *InterventionQueue
+}
+
+func (i interventionQueueOption) apply(opts *options) {
+	opts.interventions = i.queue
+}
+
+// WithHTTPClient calls remote participants with client
+func WithHTTPClient(client *http.Client) Option {
+	return httpClientOption{client}
+}
+
+type httpClientOption struct {
+	client *http.Client
+}
+
+func (h httpClientOption) apply(opts *options) {
+	if h.client != nil {
+		opts.client = h.client
+	}
+}
+
+// WithCoordinator registers a remote TCC under its global transaction on coordinator before its Try
+func WithCoordinator(coordinator *CoordinatorClient) Option {
+	return coordinatorOption{coordinator}
+}
+
+type coordinatorOption struct {
+	coordinator *CoordinatorClient
+}
+
+func (c coordinatorOption) apply(opts *options) {
+	opts.coordinator = c.coordinator
+}
+
 type Policy uint8
 
 const (
@@ -49,6 +130,7 @@ type retryOptions struct {
 	attempts int
 	interval time.Duration
 	policy   Policy
+	clock    Clock
 }
 
 type RetryOption interface {
@@ -90,3 +172,16 @@ func (p policyRetryOptions) apply(opts *retryOptions) {
 func WithPolicy(policy Policy) RetryOption {
 	return policyRetryOptions{policy}
 }
+
+type clockRetryOptions struct {
+	clock Clock
+}
+
+func (c clockRetryOptions) apply(opts *retryOptions) {
+	opts.clock = c.clock
+}
+
+// WithRetryClock waits the backoff between two attempts on clock
+func WithRetryClock(clock Clock) RetryOption {
+	return clockRetryOptions{clock}
+}
@@ -0,0 +1,213 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBarrierRejected is returned by a Try arriving after the Cancel of its branch
+var ErrBarrierRejected = errors.New("try rejected, the branch is already cancelled")
+
+// phaseTryFailed marks a Try which failed, the Try record stays so that the Cancel of the branch still runs
+const phaseTryFailed Phase = "try_failed"
+
+// BarrierStore records the phases run by the branches of global transactions
+type BarrierStore interface {
+	// Insert records op of the branch with the phase that caused it, inserted is false when op is already recorded
+	Insert(ctx context.Context, gid, branchID string, op, reason Phase) (inserted bool, err error)
+	// Reason returns the phase that recorded op of the branch
+	Reason(ctx context.Context, gid, branchID string, op Phase) (reason Phase, ok bool, err error)
+	// Delete forgets op of the branch, so that a failed phase can be retried
+	Delete(ctx context.Context, gid, branchID string, op Phase) error
+}
+
+// WithBarrier guards the phases of a TCC with a branch barrier kept in store. The branch is the id of the TCC
+// and the global transaction the one of ctx (see TransactionID), so TCCs need stable ids (see WithInfo)
+// across restarts. A Try called without a transaction starts one, which the Confirm or Cancel called after it
+// without one join.
+// A Cancel whose Try never ran is a recorded no-op, a Try arriving after it is rejected with
+// ErrBarrierRejected, and repeated phases run once. A failed phase runs again when it is repeated.
+func WithBarrier(store BarrierStore) Option {
+	return barrierOption{store}
+}
+
+type barrierOption struct {
+	store BarrierStore
+}
+
+func (b barrierOption) apply(opts *options) {
+	opts.barrier = b.store
+}
+
+type barrier struct {
+	store BarrierStore
+}
+
+func newBarrier(store BarrierStore) *barrier {
+	if store == nil {
+		return nil
+	}
+	return &barrier{store: store}
+}
+
+// enter reports whether phase of the branch must run
+func (b *barrier) enter(ctx context.Context, branch Info, phase Phase) (bool, error) {
+	gid := TransactionID(ctx)
+	if b == nil || gid == "" {
+		return true, nil
+	}
+	ctx = detachedContext{ctx}
+	switch phase {
+	case PhaseTry:
+		inserted, err := b.store.Insert(ctx, gid, branch.ID(), PhaseTry, PhaseTry)
+		if err != nil || inserted {
+			return inserted, err
+		}
+		reason, _, err := b.store.Reason(ctx, gid, branch.ID(), PhaseTry)
+		if err != nil {
+			return false, err
+		}
+		if reason == PhaseCancel {
+			// 悬挂：Cancel 已先于 Try 执行
+			return false, fmt.Errorf("%w: %s %s", ErrBarrierRejected, gid, branch.ID())
+		}
+		_, failed, err := b.store.Reason(ctx, gid, branch.ID(), phaseTryFailed)
+		if err != nil || !failed {
+			// 重复的 Try
+			return false, err
+		}
+		// 重试失败的 Try
+		return true, b.store.Delete(ctx, gid, branch.ID(), phaseTryFailed)
+	case PhaseCancel:
+		emptyRollback, err := b.store.Insert(ctx, gid, branch.ID(), PhaseTry, PhaseCancel)
+		if err != nil {
+			return false, err
+		}
+		inserted, err := b.store.Insert(ctx, gid, branch.ID(), PhaseCancel, PhaseCancel)
+		// 空回滚只记录，不执行 Cancel
+		return inserted && !emptyRollback, err
+	default:
+		return b.store.Insert(ctx, gid, branch.ID(), phase, phase)
+	}
+}
+
+// fail forgets a failed Confirm or Cancel so that it runs again when retried. A failed Try is marked
+// instead, it runs again when retried and its Cancel is not taken for an empty rollback.
+func (b *barrier) fail(ctx context.Context, branch Info, phase Phase) error {
+	gid := TransactionID(ctx)
+	if b == nil || gid == "" {
+		return nil
+	}
+	ctx = detachedContext{ctx}
+	if phase == PhaseTry {
+		_, err := b.store.Insert(ctx, gid, branch.ID(), phaseTryFailed, PhaseTry)
+		return err
+	}
+	return b.store.Delete(ctx, gid, branch.ID(), phase)
+}
+
+// NewMemoryBarrierStore returns a BarrierStore kept in process memory
+func NewMemoryBarrierStore() *memoryBarrierStore {
+	return &memoryBarrierStore{
+		records: make(map[string]Phase),
+	}
+}
+
+type memoryBarrierStore struct {
+	mutex   sync.Mutex
+	records map[string]Phase
+}
+
+func barrierKey(gid, branchID string, op Phase) string {
+	return fmt.Sprintf("%s\x00%s\x00%s", gid, branchID, op)
+}
+
+func (m *memoryBarrierStore) Insert(_ context.Context, gid, branchID string, op, reason Phase) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	key := barrierKey(gid, branchID, op)
+	if _, ok := m.records[key]; ok {
+		return false, nil
+	}
+	m.records[key] = reason
+	return true, nil
+}
+
+func (m *memoryBarrierStore) Reason(_ context.Context, gid, branchID string, op Phase) (Phase, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	reason, ok := m.records[barrierKey(gid, branchID, op)]
+	return reason, ok, nil
+}
+
+func (m *memoryBarrierStore) Delete(_ context.Context, gid, branchID string, op Phase) error {
+	m.mutex.Lock()
+	delete(m.records, barrierKey(gid, branchID, op))
+	m.mutex.Unlock()
+	return nil
+}
+
+// NewSQLBarrierStore returns a BarrierStore keeping one row per phase in table, which is expected as
+//
+//	CREATE TABLE <table> (
+//		gid         VARCHAR(128) NOT NULL,
+//		branch_id   VARCHAR(128) NOT NULL,
+//		op          VARCHAR(16)  NOT NULL,
+//		reason      VARCHAR(16)  NOT NULL,
+//		create_time BIGINT       NOT NULL, -- unix nanoseconds
+//		PRIMARY KEY (gid, branch_id, op)
+//	)
+//
+// Statements use "?" placeholders unless dollar is true ("$1", as PostgreSQL expects).
+func NewSQLBarrierStore(db *sql.DB, table string, dollar bool) *sqlBarrierStore {
+	return &sqlBarrierStore{
+		db:     db,
+		table:  table,
+		dollar: dollar,
+	}
+}
+
+type sqlBarrierStore struct {
+	db     *sql.DB
+	table  string
+	dollar bool
+}
+
+func (s *sqlBarrierStore) Insert(ctx context.Context, gid, branchID string, op, reason Phase) (bool, error) {
+	_, err := s.db.ExecContext(ctx,
+		sqlQuery("INSERT INTO %s (gid, branch_id, op, reason, create_time) VALUES (?, ?, ?, ?, ?)", s.table, s.dollar),
+		gid, branchID, string(op), string(reason), time.Now().UnixNano())
+	if err == nil {
+		return true, nil
+	}
+	// 插入冲突说明已记录
+	if _, ok, loadErr := s.Reason(ctx, gid, branchID, op); loadErr == nil && ok {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *sqlBarrierStore) Reason(ctx context.Context, gid, branchID string, op Phase) (Phase, bool, error) {
+	var reason string
+	err := s.db.QueryRowContext(ctx,
+		sqlQuery("SELECT reason FROM %s WHERE gid = ? AND branch_id = ? AND op = ?", s.table, s.dollar),
+		gid, branchID, string(op)).Scan(&reason)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return Phase(reason), true, nil
+}
+
+func (s *sqlBarrierStore) Delete(ctx context.Context, gid, branchID string, op Phase) error {
+	_, err := s.db.ExecContext(ctx,
+		sqlQuery("DELETE FROM %s WHERE gid = ? AND branch_id = ? AND op = ?", s.table, s.dollar),
+		gid, branchID, string(op))
+	return err
+}
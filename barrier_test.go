@@ -0,0 +1,141 @@
+package workflow
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBarrierEmptyRollback(t *testing.T) {
+	var tries, cancels int32
+	store := NewMemoryBarrierStore()
+	tcc := NewTCC(failingTask(0, &tries), NewFunc(UI), failingTask(0, &cancels), WithBarrier(store))
+	ctx := WithTransactionID(context.Background(), "gid-1")
+
+	// Try 未到达时的 Cancel 只记录
+	require.NoError(t, tcc.Cancel(ctx, nil))
+	assert.Equal(t, int32(0), cancels)
+	reason, ok, err := store.Reason(ctx, "gid-1", tcc.ID(), PhaseTry)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, PhaseCancel, reason)
+
+	// 迟到的 Try 被拒绝
+	assert.ErrorIs(t, tcc.Try(ctx, nil), ErrBarrierRejected)
+	assert.Equal(t, int32(0), tries)
+
+	// 其他全局事务不受影响
+	require.NoError(t, tcc.Try(WithTransactionID(context.Background(), "gid-2"), nil))
+	assert.Equal(t, int32(1), tries)
+}
+
+func TestBarrierIdempotent(t *testing.T) {
+	var tries, confirms, cancels int32
+	tcc := NewTCC(failingTask(0, &tries), failingTask(0, &confirms), failingTask(0, &cancels),
+		WithBarrier(NewMemoryBarrierStore()))
+
+	ctx := WithTransactionID(context.Background(), "gid-1")
+	require.NoError(t, tcc.Try(ctx, nil))
+	require.NoError(t, tcc.Try(ctx, nil))
+	require.NoError(t, tcc.Confirm(ctx, nil))
+	require.NoError(t, tcc.Confirm(ctx, nil))
+	assert.Equal(t, int32(1), tries)
+	assert.Equal(t, int32(1), confirms)
+
+	ctx = WithTransactionID(context.Background(), "gid-2")
+	require.NoError(t, tcc.Try(ctx, nil))
+	require.NoError(t, tcc.Cancel(ctx, nil))
+	require.NoError(t, tcc.Cancel(ctx, nil))
+	assert.Equal(t, int32(1), cancels)
+}
+
+func TestBarrierRetry(t *testing.T) {
+	var confirms int32
+	tcc := NewTCC(NewFunc(UI), failingTask(1, &confirms), NewFunc(UI),
+		WithBarrier(NewMemoryBarrierStore()), WithPhaseRetry(WithAttempt(3), WithInterval(time.Millisecond)))
+
+	// 失败的 Confirm 不会被记录为已执行
+	ctx := WithTransactionID(context.Background(), "gid-1")
+	require.NoError(t, tcc.Try(ctx, nil))
+	require.NoError(t, tcc.Confirm(ctx, nil))
+	require.NoError(t, tcc.Confirm(ctx, nil))
+	assert.Equal(t, int32(2), confirms)
+}
+
+func TestBarrierRetryTry(t *testing.T) {
+	var tries, cancels int32
+	tcc := NewTCC(failingTask(1, &tries), NewFunc(UI), failingTask(0, &cancels), WithBarrier(NewMemoryBarrierStore()))
+
+	// 失败的 Try 重试时再次执行
+	ctx := WithTransactionID(context.Background(), "gid-1")
+	assert.Error(t, tcc.Try(ctx, nil))
+	require.NoError(t, tcc.Try(ctx, nil))
+	require.NoError(t, tcc.Try(ctx, nil))
+	assert.Equal(t, int32(2), tries)
+
+	// 失败的 Try 之后 Cancel 仍然执行
+	ctx = WithTransactionID(context.Background(), "gid-2")
+	atomic.StoreInt32(&tries, 0)
+	assert.Error(t, tcc.Try(ctx, nil))
+	require.NoError(t, tcc.Cancel(ctx, nil))
+	assert.Equal(t, int32(1), cancels)
+}
+
+func TestSQLBarrierStore(t *testing.T) {
+	for _, dollar := range []bool{false, true} {
+		t.Run(fmt.Sprint(dollar), func(t *testing.T) {
+			q := func(format string) string { return sqlQuery(format, "barriers", dollar) }
+			insert := q("INSERT INTO %s (gid, branch_id, op, reason, create_time) VALUES (?, ?, ?, ?, ?)")
+			reason := q("SELECT reason FROM %s WHERE gid = ? AND branch_id = ? AND op = ?")
+			db := newScriptedSQL(t,
+				sqlStatement{query: insert, args: []interface{}{"gid-1", "pay", "try", "cancel", anyArg{}}, affected: 1},
+				// 插入冲突时读取已有的记录
+				sqlStatement{query: insert, args: []interface{}{"gid-1", "pay", "try", "try", anyArg{}}, err: errDuplicateKey},
+				sqlStatement{query: reason, args: []interface{}{"gid-1", "pay", "try"}, rows: []driver.Value{"cancel"}},
+				sqlStatement{query: reason, args: []interface{}{"gid-1", "pay", "try"}, rows: []driver.Value{"cancel"}},
+				sqlStatement{query: reason, args: []interface{}{"gid-1", "ship", "try"}},
+				sqlStatement{query: q("DELETE FROM %s WHERE gid = ? AND branch_id = ? AND op = ?"),
+					args: []interface{}{"gid-1", "pay", "try"}, affected: 1},
+				// 经由屏障执行的 TCC：空回滚，之后的 Try 被拒绝
+				sqlStatement{query: insert, args: []interface{}{"gid-2", "pay", "try", "cancel", anyArg{}}, affected: 1},
+				sqlStatement{query: insert, args: []interface{}{"gid-2", "pay", "cancel", "cancel", anyArg{}}, affected: 1},
+				sqlStatement{query: insert, args: []interface{}{"gid-2", "pay", "try", "try", anyArg{}}, err: errDuplicateKey},
+				sqlStatement{query: reason, args: []interface{}{"gid-2", "pay", "try"}, rows: []driver.Value{"cancel"}},
+				sqlStatement{query: reason, args: []interface{}{"gid-2", "pay", "try"}, rows: []driver.Value{"cancel"}},
+			)
+			store := NewSQLBarrierStore(db, "barriers", dollar)
+			ctx := context.Background()
+
+			inserted, err := store.Insert(ctx, "gid-1", "pay", PhaseTry, PhaseCancel)
+			require.NoError(t, err)
+			assert.True(t, inserted)
+			inserted, err = store.Insert(ctx, "gid-1", "pay", PhaseTry, PhaseTry)
+			require.NoError(t, err)
+			assert.False(t, inserted)
+
+			r, ok, err := store.Reason(ctx, "gid-1", "pay", PhaseTry)
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, PhaseCancel, r)
+			_, ok, err = store.Reason(ctx, "gid-1", "ship", PhaseTry)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, store.Delete(ctx, "gid-1", "pay", PhaseTry))
+
+			var cancels int32
+			tcc := NewTCC(NewFunc(UI), NewFunc(UI), failingTask(0, &cancels),
+				WithInfo(DefaultTaskInfo("pay")), WithBarrier(store))
+			txCtx := WithTransactionID(ctx, "gid-2")
+			require.NoError(t, tcc.Cancel(txCtx, nil))
+			assert.ErrorIs(t, tcc.Try(txCtx, nil), ErrBarrierRejected)
+			assert.Equal(t, int32(0), cancels)
+		})
+	}
+}
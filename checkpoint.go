@@ -0,0 +1,231 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Checkpoint is the persisted position of an execution, saved at every step of its pipelines.
+// Steps maps the id of every pipeline in progress to the index of the step it runs,
+// so pipelines need stable ids (see WithInfo) to resume after a restart.
+type Checkpoint struct {
+	ExecutionID string                     `json:"execution_id"`
+	Paused      bool                       `json:"paused"`
+	Cancelled   bool                       `json:"cancelled,omitempty"` // 挂起时被取消，再次执行时回滚
+	Steps       map[string]int             `json:"steps"`
+	Signals     map[string]json.RawMessage `json:"signals,omitempty"` // 已收到但未消费的信号
+	Pending     []PendingSignal            `json:"pending,omitempty"`
+	UpdateTime  time.Time                  `json:"update_time"`
+}
+
+// CheckpointStore persists the position of executions, it is kept for the paused, suspended and
+// interrupted ones
+type CheckpointStore interface {
+	Save(ctx context.Context, cp *Checkpoint) error
+	Load(ctx context.Context, executionID string) (*Checkpoint, bool, error)
+	Delete(ctx context.Context, executionID string) error
+	List(ctx context.Context) ([]*Checkpoint, error)
+}
+
+// NewMemoryCheckpointStore returns a CheckpointStore kept in process memory
+func NewMemoryCheckpointStore() *memoryCheckpointStore {
+	return &memoryCheckpointStore{
+		checkpoints: make(map[string][]byte),
+	}
+}
+
+type memoryCheckpointStore struct {
+	mutex       sync.Mutex
+	checkpoints map[string][]byte
+}
+
+func (m *memoryCheckpointStore) Save(_ context.Context, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	m.mutex.Lock()
+	m.checkpoints[cp.ExecutionID] = data
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *memoryCheckpointStore) Load(_ context.Context, executionID string) (*Checkpoint, bool, error) {
+	m.mutex.Lock()
+	data, ok := m.checkpoints[executionID]
+	m.mutex.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, false, err
+	}
+	return &cp, true, nil
+}
+
+func (m *memoryCheckpointStore) List(ctx context.Context) ([]*Checkpoint, error) {
+	m.mutex.Lock()
+	ids := make([]string, 0, len(m.checkpoints))
+	for id := range m.checkpoints {
+		ids = append(ids, id)
+	}
+	m.mutex.Unlock()
+	list := make([]*Checkpoint, 0, len(ids))
+	for _, id := range ids {
+		cp, ok, err := m.Load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			list = append(list, cp)
+		}
+	}
+	return list, nil
+}
+
+func (m *memoryCheckpointStore) Delete(_ context.Context, executionID string) error {
+	m.mutex.Lock()
+	delete(m.checkpoints, executionID)
+	m.mutex.Unlock()
+	return nil
+}
+
+// NewFileCheckpointStore returns a CheckpointStore keeping one json file per execution in dir
+func NewFileCheckpointStore(dir string) *fileCheckpointStore {
+	return &fileCheckpointStore{dir: dir}
+}
+
+type fileCheckpointStore struct {
+	dir string
+}
+
+func (f *fileCheckpointStore) path(executionID string) string {
+	return filepath.Join(f.dir, url.PathEscape(executionID)+".json")
+}
+
+func (f *fileCheckpointStore) Save(_ context.Context, cp *Checkpoint) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	path := f.path(cp.ExecutionID)
+	temp := path + ".tmp"
+	if err = os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+func (f *fileCheckpointStore) Load(_ context.Context, executionID string) (*Checkpoint, bool, error) {
+	data, err := os.ReadFile(f.path(executionID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	var cp Checkpoint
+	if err = json.Unmarshal(data, &cp); err != nil {
+		return nil, false, err
+	}
+	return &cp, true, nil
+}
+
+func (f *fileCheckpointStore) List(ctx context.Context) ([]*Checkpoint, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Checkpoint, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		cp, ok, err := f.Load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			list = append(list, cp)
+		}
+	}
+	return list, nil
+}
+
+func (f *fileCheckpointStore) Delete(_ context.Context, executionID string) error {
+	if err := os.Remove(f.path(executionID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// startStep returns the index a pipeline resumes from
+func startStep(ctx context.Context, info Info) int {
+	e, ok := ctx.Value(executionKey{}).(*execution)
+	if !ok {
+		return 0
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.steps[info.ID()]
+}
+
+// stepBoundary records that a pipeline is about to run step and blocks while its execution is paused.
+// The position is saved at every step, so an execution that died resumes from the step it was running.
+func stepBoundary(ctx context.Context, info Info, step int) error {
+	e, ok := ctx.Value(executionKey{}).(*execution)
+	if !ok {
+		return nil
+	}
+	e.mutex.Lock()
+	e.steps[info.ID()] = step
+	e.mutex.Unlock()
+	if err := e.save(ctx); err != nil {
+		return err
+	}
+	run := runOf(ctx, info)
+	for {
+		e.mutex.Lock()
+		paused, resume := e.paused, e.resume
+		e.mutex.Unlock()
+		if !paused {
+			return nil
+		}
+		state := run.State()
+		run.SetState(Paused)
+		if err := e.save(ctx); err != nil {
+			run.SetState(state)
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resume:
+			run.SetState(state)
+		}
+	}
+}
+
+// stepsDone forgets the position of a finished pipeline
+func stepsDone(ctx context.Context, info Info) {
+	e, ok := ctx.Value(executionKey{}).(*execution)
+	if !ok {
+		return
+	}
+	e.mutex.Lock()
+	delete(e.steps, info.ID())
+	e.mutex.Unlock()
+}
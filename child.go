@@ -0,0 +1,97 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// link schedules child as a step of parent. A TCC is linked to the run of parent executing in ctx,
+// a task links each of its runs when it starts, the returned ctx holds the step of the task.
+func link(ctx context.Context, parent, child Info) context.Context {
+	if _, ok := child.(Task); !ok {
+		run := runOf(ctx, parent)
+		child.SetParent(run)
+		addChild(run, child)
+		if id := ExecutionID(ctx); id != "" {
+			child.SetExecutionID(id)
+		}
+	}
+	if h := historyFrom(ctx); h != nil {
+		var err error
+		if ctx, err = h.link(ctx, parent, child); err != nil {
+			h.fail(err)
+		}
+	}
+	return ctx
+}
+
+// Register defines the workflow name, factory builds a new instance for every run
+func (r *registry) Register(name string, factory func() Task) {
+	r.mutex.Lock()
+	r.workflows[name] = factory
+	r.mutex.Unlock()
+}
+
+// SubWorkflow returns a step that runs the workflow registered as name as its child.
+// The child collects its own outputs, which are recorded as the output name of the parent.
+func (r *registry) SubWorkflow(name string, opts ...Option) Task {
+	opt := &options{
+		info: DefaultTaskInfo(""),
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
+	// 初始化状态
+	opt.info.SetName(name)
+	opt.info.SetState(Ready)
+	opt.info.SetDescription("sub workflow")
+	return &subWorkflow{
+		Info:      opt.info,
+		registry:  r,
+		name:      name,
+		callbacks: opt.callbacks,
+	}
+}
+
+type subWorkflow struct {
+	Info
+	registry  *registry
+	name      string
+	callbacks []Callback
+}
+
+func (s *subWorkflow) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, s)
+	info.SetState(Running)
+	s.registry.mutex.Lock()
+	factory, ok := s.registry.workflows[s.name]
+	s.registry.mutex.Unlock()
+	var err error
+	if ok {
+		child := factory()
+		childCtx := WithOutputs(link(ctx, s, child))
+		if err = child.Execute(childCtx, input); err == nil {
+			SetOutput(ctx, s.name, Outputs(childCtx))
+		}
+	} else {
+		err = fmt.Errorf("workflow %q is not registered", s.name)
+	}
+	if errors.Is(err, ErrSuspended) {
+		info.SetState(Waiting)
+	} else {
+		info.AddError(err)
+		markCancelled(ctx, info)
+	}
+	for _, callback := range s.callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	for _, callback := range callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	return err
+}
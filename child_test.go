@@ -0,0 +1,63 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func recordRun(run *Info) Callback {
+	return callbackFunc(func(_ context.Context, info Info, _ interface{}, _ error) {
+		*run = info
+	})
+}
+
+func TestParentLinkage(t *testing.T) {
+	var stepRun, pipelineRun Info
+	step := NewFunc(UI, WithCallbacks(recordRun(&stepRun)))
+	pipeline := NewTaskPipeline(WithCallbacks(recordRun(&pipelineRun))).WithTasks(step)
+	tcc := NewTCC(pipeline, NewFunc(UI), NewFunc(UI))
+	group := NewTCCGroup().WithTCCs(tcc)
+
+	r := NewRegistry()
+	assert.NoError(t, r.ExecuteTCC(context.Background(), "exec-1", group, nil))
+	assert.Equal(t, step.ID(), stepRun.DefinitionID())
+	assert.Equal(t, pipelineRun.ID(), stepRun.ParentID())
+	assert.Equal(t, tcc.ID(), pipelineRun.ParentID())
+	assert.Equal(t, group.ID(), tcc.ParentID())
+	assert.Equal(t, "", group.ParentID())
+	// 定义不随运行改变
+	assert.Equal(t, "", step.ParentID())
+	assert.Equal(t, Ready, step.State())
+	for _, info := range []Info{stepRun, pipelineRun, tcc, group} {
+		assert.Equal(t, group.ID(), info.RootID())
+		assert.Equal(t, "exec-1", info.ExecutionID())
+	}
+}
+
+func TestSubWorkflow(t *testing.T) {
+	r := NewRegistry()
+	var child, subRun, pipelineRun Info
+	r.Register("provision", func() Task {
+		return NewFunc(func(ctx context.Context, i interface{}) error {
+			SetOutput(ctx, "host", "10.0.0.1")
+			return nil
+		}, WithCallbacks(recordRun(&child)))
+	})
+	var host interface{}
+	sub := r.SubWorkflow("provision", WithCallbacks(recordRun(&subRun)))
+	pipeline := NewTaskPipeline(WithCallbacks(recordRun(&pipelineRun))).WithTasks(sub,
+		NewFunc(func(ctx context.Context, i interface{}) error {
+			v, _ := Output(ctx, "provision")
+			host = v.(map[string]interface{})["host"]
+			return nil
+		}))
+	assert.NoError(t, r.Execute(context.Background(), "", pipeline, nil))
+	assert.Equal(t, "10.0.0.1", host)
+	assert.Equal(t, subRun.ID(), child.ParentID())
+	assert.Equal(t, pipelineRun.ID(), child.RootID())
+
+	assert.EqualError(t, r.Execute(context.Background(), "", r.SubWorkflow("missing"), nil),
+		`workflow "missing" is not registered`)
+}
@@ -0,0 +1,55 @@
+package workflow
+
+import "time"
+
+// Clock is the source of time of time wheels and retries, see the workflowtest package for a fake one.
+// Infos take the Now of a clock, see DefaultTaskInfo.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// SystemClock is the Clock of the system time
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return systemTicker{time.NewTicker(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (s systemTimer) C() <-chan time.Time {
+	return s.Timer.C
+}
+
+type systemTicker struct {
+	*time.Ticker
+}
+
+func (s systemTicker) C() <-chan time.Time {
+	return s.Ticker.C
+}
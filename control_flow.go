@@ -0,0 +1,242 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrMaxIterations = errors.New("loop reached max iterations")
+
+// Predicate decides over the input and the outputs recorded in ctx
+type Predicate func(ctx context.Context, input interface{}) bool
+
+// ChoiceMetadata is the metadata of a choice task, Branch is empty when no branch was taken
+type ChoiceMetadata struct {
+	Branch string `json:"branch"`
+}
+
+// LoopMetadata is the metadata of a loop task
+type LoopMetadata struct {
+	Iterations int `json:"iterations"`
+}
+
+func NewChoiceTask(opts ...Option) *noopChoiceTask {
+	opt := &options{
+		info: DefaultTaskInfo(""),
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
+	// 初始化状态
+	opt.info.SetName("choice-task")
+	opt.info.SetState(Ready)
+	opt.info.SetDescription("choice task")
+	return &noopChoiceTask{
+		Info:      opt.info,
+		callbacks: opt.callbacks,
+	}
+}
+
+type choiceBranch struct {
+	name      string
+	predicate Predicate
+	task      Task
+}
+
+type noopChoiceTask struct {
+	Info
+	branches  []choiceBranch
+	callbacks []Callback
+}
+
+// When adds a branch, the first branch whose predicate holds is taken
+func (n *noopChoiceTask) When(name string, predicate Predicate, task Task) *noopChoiceTask {
+	n.branches = append(n.branches, choiceBranch{name: name, predicate: predicate, task: task})
+	return n
+}
+
+// WithDefault returns the choice task, task runs when no branch is taken and may be nil
+func (n *noopChoiceTask) WithDefault(task Task) Task {
+	return &choiceTask{
+		noopChoiceTask: n,
+		otherwise:      task,
+	}
+}
+
+type choiceTask struct {
+	*noopChoiceTask
+	otherwise Task
+}
+
+func (c *choiceTask) children() []Info {
+	children := make([]Info, 0, len(c.branches)+1)
+	for _, branch := range c.branches {
+		children = append(children, branch.task)
+	}
+	if c.otherwise != nil {
+		children = append(children, c.otherwise)
+	}
+	return children
+}
+
+func (c *choiceTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, c)
+	info.SetState(Running)
+	branch, task := "", c.otherwise
+	if task != nil {
+		branch = "default"
+	}
+	for _, b := range c.branches {
+		if b.predicate(ctx, input) {
+			branch, task = b.name, b.task
+			break
+		}
+	}
+	setMetadata(info, ChoiceMetadata{Branch: branch})
+	var err error
+	if task != nil {
+		err = task.Execute(link(ctx, c, task), input)
+		info.AddError(err)
+	} else {
+		// 没有分支被选中
+		info.SetState(Skipped)
+	}
+	markCancelled(ctx, info)
+	for _, callback := range c.callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	for _, callback := range callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	return err
+}
+
+// NewWhileTask repeats a task as long as cond holds, cond is checked before every iteration
+func NewWhileTask(cond Predicate, opts ...Option) *noopLoopTask {
+	return newLoopTask("while-task", cond, false, opts)
+}
+
+// NewUntilTask repeats a task until cond holds, cond is checked after every iteration
+func NewUntilTask(cond Predicate, opts ...Option) *noopLoopTask {
+	return newLoopTask("until-task", cond, true, opts)
+}
+
+func newLoopTask(name string, cond Predicate, until bool, opts []Option) *noopLoopTask {
+	opt := &options{
+		info: DefaultTaskInfo(""),
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
+	// 初始化状态
+	opt.info.SetName(name)
+	opt.info.SetState(Ready)
+	opt.info.SetDescription("loop task")
+	return &noopLoopTask{
+		Info:          opt.info,
+		cond:          cond,
+		until:         until,
+		maxIterations: defaultAttempt,
+		clock:         SystemClock,
+		callbacks:     opt.callbacks,
+	}
+}
+
+type noopLoopTask struct {
+	Info
+	cond          Predicate
+	until         bool
+	maxIterations int
+	delay         time.Duration
+	clock         Clock
+	callbacks     []Callback
+}
+
+// WithMaxIterations set the iteration count after which the loop fails with ErrMaxIterations
+func (n *noopLoopTask) WithMaxIterations(maxIterations int) *noopLoopTask {
+	n.maxIterations = maxIterations
+	return n
+}
+
+// WithDelay set the pause between two iterations
+func (n *noopLoopTask) WithDelay(delay time.Duration) *noopLoopTask {
+	n.delay = delay
+	return n
+}
+
+// WithClock waits the delay between two iterations on clock
+func (n *noopLoopTask) WithClock(clock Clock) *noopLoopTask {
+	n.clock = clock
+	return n
+}
+
+func (n *noopLoopTask) WithTask(task Task) Task {
+	return &loopTask{
+		noopLoopTask: n,
+		task:         task,
+	}
+}
+
+type loopTask struct {
+	*noopLoopTask
+	task Task
+}
+
+func (l *loopTask) children() []Info {
+	return []Info{l.task}
+}
+
+func (l *loopTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, l)
+	info.SetState(Running)
+	// 每次迭代都是同一步骤的一次运行
+	err := l.loop(link(ctx, l, l.task), info, input)
+	info.AddError(err)
+	markCancelled(ctx, info)
+	for _, callback := range l.callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	for _, callback := range callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	return err
+}
+
+func (l *loopTask) loop(ctx context.Context, info Info, input interface{}) error {
+	iterations := 0
+	setMetadata(info, LoopMetadata{Iterations: iterations})
+	for {
+		if !l.until && !l.cond(ctx, input) {
+			return nil
+		}
+		if iterations >= l.maxIterations {
+			return ErrMaxIterations
+		}
+		if iterations > 0 && l.delay > 0 {
+			timer := l.clock.NewTimer(l.delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C():
+			}
+		}
+		if err := l.task.Execute(ctx, input); err != nil {
+			return err
+		}
+		iterations++
+		setMetadata(info, LoopMetadata{Iterations: iterations})
+		if l.until && l.cond(ctx, input) {
+			return nil
+		}
+	}
+}
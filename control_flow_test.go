@@ -0,0 +1,65 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChoiceTask(t *testing.T) {
+	var taken string
+	branch := func(name string) Task {
+		return NewFunc(func(context.Context, interface{}) error {
+			taken = name
+			return nil
+		})
+	}
+	choice := NewChoiceTask().
+		When("small", func(ctx context.Context, input interface{}) bool { return input.(int) < 10 }, branch("small")).
+		When("medium", func(ctx context.Context, input interface{}) bool { return input.(int) < 100 }, branch("medium")).
+		WithDefault(branch("large"))
+
+	for input, expected := range map[int]string{1: "small", 50: "medium", 500: "large"} {
+		run, err := Run(context.Background(), choice, input)
+		require.NoError(t, err)
+		assert.Equal(t, expected, taken)
+		var meta ChoiceMetadata
+		require.NoError(t, json.Unmarshal(run.Metadata(), &meta))
+		if expected == "large" {
+			expected = "default"
+		}
+		assert.Equal(t, expected, meta.Branch)
+	}
+}
+
+func TestLoopTask(t *testing.T) {
+	count := 0
+	body := NewFunc(func(context.Context, interface{}) error {
+		count++
+		return nil
+	})
+	below := func(n int) Predicate {
+		return func(context.Context, interface{}) bool { return count < n }
+	}
+
+	while := NewWhileTask(below(3)).WithDelay(time.Millisecond).WithTask(body)
+	run, err := Run(context.Background(), while, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.JSONEq(t, `{"iterations":3}`, string(run.Metadata()))
+
+	// until 至少执行一次
+	until := NewUntilTask(func(context.Context, interface{}) bool { return true }).WithTask(body)
+	require.NoError(t, until.Execute(context.Background(), nil))
+	assert.Equal(t, 4, count)
+
+	endless := NewWhileTask(func(context.Context, interface{}) bool { return true }).
+		WithMaxIterations(2).WithTask(body)
+	run, err = Run(context.Background(), endless, nil)
+	assert.ErrorIs(t, err, ErrMaxIterations)
+	assert.JSONEq(t, `{"iterations":2}`, string(run.Metadata()))
+}
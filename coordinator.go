@@ -0,0 +1,327 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/multierr"
+)
+
+var (
+	ErrTransactionNotFound = errors.New("transaction not found")
+	ErrTransactionFinished = errors.New("transaction already finished")
+	ErrTransactionExists   = errors.New("transaction already exists")
+)
+
+// finishedRetention is the number of finished transactions a coordinator keeps
+const finishedRetention = 1024
+
+// Transaction is a global transaction and the branches registered under it
+type Transaction struct {
+	ID         string         `json:"id"`
+	State      State          `json:"state"`
+	Branches   []RemoteBranch `json:"branches"`
+	Error      string         `json:"error,omitempty"`
+	CreateTime time.Time      `json:"create_time"`
+	UpdateTime time.Time      `json:"update_time"`
+	finishing  bool
+	phase      Phase // 提交或回滚，失败后只能以同一阶段重试
+}
+
+// NewCoordinator returns a coordinator of global transactions, opts apply to the TCC of every branch
+// (see NewRemoteTCC)
+func NewCoordinator(opts ...Option) *Coordinator {
+	return &Coordinator{
+		opts:         opts,
+		transactions: make(map[string]*Transaction),
+		retention:    finishedRetention,
+	}
+}
+
+// Coordinator keeps the branches of global transactions and drives their Confirm or Cancel
+type Coordinator struct {
+	opts         []Option
+	mutex        sync.Mutex
+	transactions map[string]*Transaction
+	finished     []string // 已成功提交或回滚的全局事务，最早的在前
+	retention    int
+}
+
+// WithRetention set how many successfully submitted or aborted transactions are kept, the oldest
+// are forgotten first. The running and failed ones are kept until they finish.
+func (c *Coordinator) WithRetention(retention int) *Coordinator {
+	if retention >= 0 {
+		c.retention = retention
+	}
+	return c
+}
+
+// Begin starts the global transaction gid, a new id is generated when gid is empty
+func (c *Coordinator) Begin(gid string) (Transaction, error) {
+	if gid == "" {
+		gid = uuid.NewV4().String()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t, ok := c.transactions[gid]; ok {
+		if t.State == Success || t.State == Cancelled {
+			return c.copy(t), fmt.Errorf("%w: %s", ErrTransactionFinished, gid)
+		}
+		return c.copy(t), fmt.Errorf("%w: %s", ErrTransactionExists, gid)
+	}
+	now := time.Now()
+	t := &Transaction{
+		ID:         gid,
+		State:      Running,
+		Branches:   []RemoteBranch{},
+		CreateTime: now,
+		UpdateTime: now,
+	}
+	c.transactions[gid] = t
+	return *t, nil
+}
+
+// Get returns the global transaction gid
+func (c *Coordinator) Get(gid string) (Transaction, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t, ok := c.transactions[gid]
+	if !ok {
+		return Transaction{}, fmt.Errorf("%w: %s", ErrTransactionNotFound, gid)
+	}
+	return c.copy(t), nil
+}
+
+// Register adds branch to the running global transaction gid, registering it again does nothing
+func (c *Coordinator) Register(gid string, branch RemoteBranch) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t, err := c.running(gid)
+	if err != nil {
+		return err
+	}
+	for _, b := range t.Branches {
+		if b.ID == branch.ID {
+			return nil
+		}
+	}
+	t.Branches = append(t.Branches, branch)
+	t.UpdateTime = time.Now()
+	return nil
+}
+
+// Submit confirms every branch of the global transaction gid in the order of registration,
+// with the input of its Try.
+// A failed Submit can be retried, the branches run after ctx is done.
+func (c *Coordinator) Submit(ctx context.Context, gid string) error {
+	return c.finish(ctx, gid, PhaseConfirm)
+}
+
+// Abort cancels every branch of the global transaction gid in the reverse order of registration,
+// with the input of its Try.
+// A failed Abort can be retried, the branches run after ctx is done.
+func (c *Coordinator) Abort(ctx context.Context, gid string) error {
+	return c.finish(ctx, gid, PhaseCancel)
+}
+
+func (c *Coordinator) finish(ctx context.Context, gid string, phase Phase) error {
+	c.mutex.Lock()
+	t, err := c.finishable(gid, phase)
+	if err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+	// 防止并发的提交与回滚
+	t.finishing = true
+	t.phase = phase
+	branches := append([]RemoteBranch(nil), t.Branches...)
+	c.mutex.Unlock()
+
+	// 请求结束后分支仍需完成
+	ctx = WithTransactionID(detachedContext{ctx}, gid)
+	for i := range branches {
+		branch := branches[i]
+		if phase == PhaseCancel {
+			branch = branches[len(branches)-1-i]
+		}
+		info := DefaultTaskInfo(branch.ID)
+		info.SetName(branch.ID)
+		tcc := NewRemoteTCC(branch, append(c.opts, WithInfo(info))...)
+		if phase == PhaseConfirm {
+			err = multierr.Append(err, tcc.Confirm(ctx, branch.Input))
+		} else {
+			err = multierr.Append(err, tcc.Cancel(ctx, branch.Input))
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	t.finishing = false
+	switch {
+	case err != nil:
+		t.State = Error
+		t.Error = err.Error()
+	case phase == PhaseConfirm:
+		t.State = Success
+		t.Error = ""
+	default:
+		t.State = Cancelled
+		t.Error = ""
+	}
+	t.UpdateTime = time.Now()
+	if err == nil {
+		c.forget(gid)
+	}
+	return err
+}
+
+// finishable returns the transaction gid when it can run phase: it is running,
+// or it failed to run the same phase before
+func (c *Coordinator) finishable(gid string, phase Phase) (*Transaction, error) {
+	t, ok := c.transactions[gid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, gid)
+	}
+	if t.State == Error && t.phase == phase && !t.finishing {
+		return t, nil
+	}
+	return c.running(gid)
+}
+
+// forget records gid as finished and drops the oldest finished transactions beyond the retention
+func (c *Coordinator) forget(gid string) {
+	c.finished = append(c.finished, gid)
+	for len(c.finished) > c.retention {
+		delete(c.transactions, c.finished[0])
+		c.finished = c.finished[1:]
+	}
+}
+
+func (c *Coordinator) running(gid string) (*Transaction, error) {
+	t, ok := c.transactions[gid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTransactionNotFound, gid)
+	}
+	if t.State != Running || t.finishing {
+		return nil, fmt.Errorf("%w: %s is %s", ErrTransactionFinished, gid, t.State)
+	}
+	return t, nil
+}
+
+func (c *Coordinator) copy(t *Transaction) Transaction {
+	v := *t
+	v.Branches = append([]RemoteBranch{}, t.Branches...)
+	return v
+}
+
+// CoordinatorHandler serves coordinator: POST begins a transaction ({"id": gid}, a new one without a body),
+// GET {gid} returns one,
+// POST {gid}/branches registers a RemoteBranch and POST {gid}/submit or {gid}/abort finishes it
+func CoordinatorHandler(coordinator *Coordinator) http.Handler {
+	return &coordinatorHandler{coordinator: coordinator}
+}
+
+type coordinatorHandler struct {
+	coordinator *Coordinator
+}
+
+func (h *coordinatorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && parts[0] == "":
+		var req struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		t, err := h.coordinator.Begin(req.ID)
+		h.reply(w, http.StatusCreated, t, err)
+	case r.Method == http.MethodGet && len(parts) == 1 && parts[0] != "":
+		t, err := h.coordinator.Get(parts[0])
+		h.reply(w, http.StatusOK, t, err)
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "branches":
+		var branch RemoteBranch
+		if err := json.NewDecoder(r.Body).Decode(&branch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.reply(w, http.StatusNoContent, nil, h.coordinator.Register(parts[0], branch))
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "submit":
+		h.reply(w, http.StatusNoContent, nil, h.coordinator.Submit(r.Context(), parts[0]))
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "abort":
+		h.reply(w, http.StatusNoContent, nil, h.coordinator.Abort(r.Context(), parts[0]))
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (h *coordinatorHandler) reply(w http.ResponseWriter, status int, v interface{}, err error) {
+	switch {
+	case errors.Is(err, ErrTransactionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case v == nil:
+		w.WriteHeader(status)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(v)
+	}
+}
+
+// NewCoordinatorClient returns a client of the coordinator served by CoordinatorHandler at url
+func NewCoordinatorClient(endpoint string, client *http.Client) *CoordinatorClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &CoordinatorClient{
+		url:    strings.TrimSuffix(endpoint, "/"),
+		client: client,
+	}
+}
+
+// CoordinatorClient begins and finishes global transactions on a remote coordinator
+type CoordinatorClient struct {
+	url    string
+	client *http.Client
+}
+
+// Begin starts the global transaction gid, ctx joined to it is returned
+func (c *CoordinatorClient) Begin(ctx context.Context, gid string) (context.Context, error) {
+	var t Transaction
+	if err := postJSON(ctx, c.client, c.url+"/", map[string]string{"id": gid}, &t); err != nil {
+		return ctx, err
+	}
+	return WithTransactionID(ctx, t.ID), nil
+}
+
+// Register adds branch to the global transaction of ctx
+func (c *CoordinatorClient) Register(ctx context.Context, branch RemoteBranch) error {
+	return postJSON(ctx, c.client, c.path(ctx, "branches"), branch, nil)
+}
+
+// Submit confirms the branches of the global transaction of ctx
+func (c *CoordinatorClient) Submit(ctx context.Context) error {
+	return postJSON(ctx, c.client, c.path(ctx, "submit"), nil, nil)
+}
+
+// Abort cancels the branches of the global transaction of ctx
+func (c *CoordinatorClient) Abort(ctx context.Context) error {
+	return postJSON(ctx, c.client, c.path(ctx, "abort"), nil, nil)
+}
+
+func (c *CoordinatorClient) path(ctx context.Context, action string) string {
+	return fmt.Sprintf("%s/%s/%s", c.url, url.PathEscape(TransactionID(ctx)), action)
+}
@@ -0,0 +1,255 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// participant is a remote service counting its phases and their inputs per global transaction
+type participant struct {
+	*httptest.Server
+	handler *participantHandler
+	mutex   sync.Mutex
+	phases  map[string][]Phase
+	inputs  map[string][]string
+	fail    bool
+}
+
+func newParticipant(fail bool) *participant {
+	p := &participant{phases: make(map[string][]Phase), inputs: make(map[string][]string), fail: fail}
+	phase := func(phase Phase) Task {
+		return NewFunc(func(ctx context.Context, input interface{}) error {
+			p.mutex.Lock()
+			defer p.mutex.Unlock()
+			gid := TransactionID(ctx)
+			p.phases[gid] = append(p.phases[gid], phase)
+			p.inputs[gid] = append(p.inputs[gid], string(input.(json.RawMessage)))
+			if phase == PhaseTry && p.fail {
+				return errors.New("out of stock")
+			}
+			return nil
+		})
+	}
+	store := NewMemoryBarrierStore()
+	p.handler = ParticipantHandler(func(string) TCC {
+		return NewTCC(phase(PhaseTry), phase(PhaseConfirm), phase(PhaseCancel),
+			WithInfo(DefaultTaskInfo("participant")), WithBarrier(store))
+	}).(*participantHandler)
+	p.Server = httptest.NewServer(p.handler)
+	return p
+}
+
+func (p *participant) branch(id string) RemoteBranch {
+	return RemoteBranch{ID: id, Try: p.URL + "/try", Confirm: p.URL + "/confirm", Cancel: p.URL + "/cancel"}
+}
+
+func (p *participant) Phases(gid string) []Phase {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.phases[gid]
+}
+
+func (p *participant) Inputs(gid string) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.inputs[gid]
+}
+
+// running returns the number of global transactions whose TCC is kept
+func (p *participant) running() int {
+	p.handler.mutex.Lock()
+	defer p.handler.mutex.Unlock()
+	return len(p.handler.tccs)
+}
+
+func TestCoordinator(t *testing.T) {
+	coordinator := NewCoordinator()
+	server := httptest.NewServer(CoordinatorHandler(coordinator))
+	defer server.Close()
+	client := NewCoordinatorClient(server.URL, server.Client())
+	stock, payment := newParticipant(false), newParticipant(false)
+	defer stock.Close()
+	defer payment.Close()
+
+	ctx, err := client.Begin(context.Background(), "order-1")
+	require.NoError(t, err)
+	tcc := NewTCCPipeline().WithTCCs(
+		NewRemoteTCC(stock.branch("stock"), WithCoordinator(client)),
+		NewRemoteTCC(payment.branch("payment"), WithCoordinator(client)),
+	)
+	require.NoError(t, tcc.Try(ctx, map[string]int{"amount": 1}))
+	require.NoError(t, client.Submit(ctx))
+	assert.Equal(t, []Phase{PhaseTry, PhaseConfirm}, stock.Phases("order-1"))
+	assert.Equal(t, []Phase{PhaseTry, PhaseConfirm}, payment.Phases("order-1"))
+	// Confirm 收到 Try 的输入
+	assert.Equal(t, []string{`{"amount":1}`, `{"amount":1}`}, stock.Inputs("order-1"))
+	assert.Zero(t, stock.running())
+
+	transaction, err := coordinator.Get("order-1")
+	require.NoError(t, err)
+	assert.Equal(t, Success, transaction.State)
+	input := json.RawMessage(`{"amount":1}`)
+	branches := []RemoteBranch{stock.branch("stock"), payment.branch("payment")}
+	for i := range branches {
+		branches[i].Input = input
+	}
+	assert.Equal(t, branches, transaction.Branches)
+	assert.ErrorIs(t, coordinator.Abort(context.Background(), "order-1"), ErrTransactionFinished)
+	assert.ErrorIs(t, client.Submit(ctx), ErrRemote)
+}
+
+func TestCoordinatorAbort(t *testing.T) {
+	coordinator := NewCoordinator()
+	server := httptest.NewServer(CoordinatorHandler(coordinator))
+	defer server.Close()
+	client := NewCoordinatorClient(server.URL, nil)
+	stock, payment, shipping := newParticipant(false), newParticipant(true), newParticipant(false)
+	defer stock.Close()
+	defer payment.Close()
+	defer shipping.Close()
+
+	ctx, err := client.Begin(context.Background(), "")
+	require.NoError(t, err)
+	gid := TransactionID(ctx)
+	require.NotEmpty(t, gid)
+	tcc := NewTCCPipeline().WithTCCs(
+		NewRemoteTCC(stock.branch("stock"), WithCoordinator(client)),
+		NewRemoteTCC(payment.branch("payment"), WithCoordinator(client)),
+		NewRemoteTCC(shipping.branch("shipping"), WithCoordinator(client)),
+	)
+	assert.ErrorIs(t, tcc.Try(ctx, nil), ErrRemote)
+	require.NoError(t, client.Abort(ctx))
+	assert.Equal(t, []Phase{PhaseTry, PhaseCancel}, stock.Phases(gid))
+	assert.Equal(t, []Phase{PhaseTry, PhaseCancel}, payment.Phases(gid))
+	assert.Empty(t, shipping.Phases(gid))
+
+	transaction, err := coordinator.Get(gid)
+	require.NoError(t, err)
+	assert.Equal(t, Cancelled, transaction.State)
+	assert.Len(t, transaction.Branches, 2)
+}
+
+func TestParticipantHandler(t *testing.T) {
+	stock := newParticipant(false)
+	defer stock.Close()
+	ctx := WithTransactionID(context.Background(), "order-2")
+
+	// 空回滚后迟到的 Try 被拒绝
+	cancel := NewRemoteTCC(stock.branch("stock"))
+	require.NoError(t, cancel.Cancel(ctx, nil))
+	err := cancel.Try(ctx, nil)
+	assert.ErrorIs(t, err, ErrRemote)
+	assert.Contains(t, err.Error(), "409")
+	assert.Empty(t, stock.Phases("order-2"))
+	assert.Zero(t, stock.running())
+
+	// 并发的全局事务各自使用一个 TCC
+	first, second := WithTransactionID(ctx, "order-3"), WithTransactionID(ctx, "order-4")
+	tcc := NewRemoteTCC(stock.branch("stock"))
+	require.NoError(t, tcc.Try(first, "first"))
+	require.NoError(t, tcc.Try(second, "second"))
+	assert.Equal(t, 2, stock.running())
+	require.NoError(t, tcc.Confirm(first, "first"))
+	require.NoError(t, tcc.Cancel(second, "second"))
+	assert.Equal(t, []Phase{PhaseTry, PhaseConfirm}, stock.Phases("order-3"))
+	assert.Equal(t, []Phase{PhaseTry, PhaseCancel}, stock.Phases("order-4"))
+	assert.Zero(t, stock.running())
+
+	resp, err := http.Get(stock.URL + "/try")
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp, err = http.Post(stock.URL+"/try", "application/json", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 不带请求体开始事务时生成全局事务 id
+	server := httptest.NewServer(CoordinatorHandler(NewCoordinator()))
+	defer server.Close()
+	resp, err = http.Post(server.URL, "application/json", nil)
+	require.NoError(t, err)
+	var transaction Transaction
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&transaction))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.NotEmpty(t, transaction.ID)
+	_, err = NewCoordinator().Get("order-2")
+	assert.ErrorIs(t, err, ErrTransactionNotFound)
+}
+
+func TestCoordinatorRetry(t *testing.T) {
+	var (
+		mutex    sync.Mutex
+		down     = true
+		confirms int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		confirms++
+	}))
+	defer server.Close()
+	coordinator := NewCoordinator()
+
+	_, err := coordinator.Begin("order-3")
+	require.NoError(t, err)
+	_, err = coordinator.Begin("order-3")
+	assert.ErrorIs(t, err, ErrTransactionExists)
+	branch := RemoteBranch{ID: "stock", Confirm: server.URL + "/confirm", Cancel: server.URL + "/cancel"}
+	require.NoError(t, coordinator.Register("order-3", branch))
+
+	// 请求结束不影响提交，失败后只能以同一阶段重试
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, coordinator.Submit(ctx, "order-3"), ErrRemote)
+	transaction, err := coordinator.Get("order-3")
+	require.NoError(t, err)
+	assert.Equal(t, Error, transaction.State)
+	assert.ErrorIs(t, coordinator.Abort(context.Background(), "order-3"), ErrTransactionFinished)
+	assert.ErrorIs(t, coordinator.Register("order-3", branch), ErrTransactionFinished)
+
+	mutex.Lock()
+	down = false
+	mutex.Unlock()
+	require.NoError(t, coordinator.Submit(context.Background(), "order-3"))
+	transaction, err = coordinator.Get("order-3")
+	require.NoError(t, err)
+	assert.Equal(t, Success, transaction.State)
+	assert.Empty(t, transaction.Error)
+	assert.Equal(t, 1, confirms)
+	_, err = coordinator.Begin("order-3")
+	assert.ErrorIs(t, err, ErrTransactionFinished)
+}
+
+func TestCoordinatorRetention(t *testing.T) {
+	coordinator := NewCoordinator().WithRetention(1)
+	for _, gid := range []string{"order-4", "order-5", "order-6"} {
+		_, err := coordinator.Begin(gid)
+		require.NoError(t, err)
+	}
+	require.NoError(t, coordinator.Submit(context.Background(), "order-4"))
+	require.NoError(t, coordinator.Abort(context.Background(), "order-5"))
+
+	// 运行中的事务不会被淘汰
+	_, err := coordinator.Get("order-4")
+	assert.ErrorIs(t, err, ErrTransactionNotFound)
+	transaction, err := coordinator.Get("order-5")
+	require.NoError(t, err)
+	assert.Equal(t, Cancelled, transaction.State)
+	transaction, err = coordinator.Get("order-6")
+	require.NoError(t, err)
+	assert.Equal(t, Running, transaction.State)
+}
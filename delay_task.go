@@ -8,19 +8,24 @@ import (
 
 type DelayTask struct {
 	Delay time.Duration
+	// Run executes the task when the timer fires, the callbacks then get its error
+	Run bool
 	Task
 }
 
 // timeWheel 时间轮
 type timeWheel struct {
 	interval time.Duration // 指针每隔多久往前移动一格
-	ticker   *time.Ticker
+	clock    Clock
+	ticker   Ticker
 	slots    []*list.List // 时间轮槽
 	// key: 定时器唯一标识 value: 定时器所在的槽, 主要用于删除定时器, 不会出现并发读写，不加锁直接访问
 	timer             map[string]int
 	cur               int             // 当前指针指向哪一个槽
 	slotSum           int             // 槽数量
 	callback          []Callback      // 定时器回调函数
+	leader            Leader          // 多副本时只有领导者触发定时器
+	due               []*dueTimer     // 非领导者保留的到期定时器，用于故障转移
 	addTaskChannel    chan *taskEntry // 新增任务channel
 	removeTaskChannel chan string     // 删除任务channel
 	stopChannel       chan struct{}   // 停止定时器channel
@@ -32,9 +37,70 @@ type taskEntry struct {
 	removed bool
 }
 
-func NewTimeWheel(interval time.Duration, slotNum int) *timeWheel {
+type dueTimer struct {
+	DelayTask
+	since time.Time
+}
+
+// claimer is a Leader that claims every firing of a timer, so that the timers fired by a leader
+// are not fired again by its successor
+type claimer interface {
+	Leader
+	// claim takes the firing of the timer id, it reports false when another replica took it
+	claim(ctx context.Context, id string) (bool, error)
+	// failover is how long the other replicas keep a due timer, waiting to become the leader
+	failover() time.Duration
+}
+
+type TimeWheelOption interface {
+	apply(*timeWheel)
+}
+
+type leaderTimeWheelOption struct {
+	leader Leader
+}
+
+func (l leaderTimeWheelOption) apply(tw *timeWheel) {
+	tw.leader = l.leader
+}
+
+type clockTimeWheelOption struct {
+	clock Clock
+}
+
+func (c clockTimeWheelOption) apply(tw *timeWheel) {
+	tw.clock = c.clock
+}
+
+// WithTimeWheelClock moves the pointer with the ticks of clock
+func WithTimeWheelClock(clock Clock) TimeWheelOption {
+	return clockTimeWheelOption{clock}
+}
+
+type callbacksTimeWheelOption struct {
+	callbacks []Callback
+}
+
+func (c callbacksTimeWheelOption) apply(tw *timeWheel) {
+	tw.callback = c.callbacks
+}
+
+// WithTimerCallbacks triggers callbacks with the task of every timer that fires
+func WithTimerCallbacks(callbacks ...Callback) TimeWheelOption {
+	return callbacksTimeWheelOption{callbacks}
+}
+
+// WithLeader only fires timers while leader.IsLeader. The other replicas drop their due timers,
+// unless leader comes from NewElector: every firing is then claimed in its locker, and the
+// other replicas keep their due timers during a failover and fire the ones the dead leader did not.
+func WithLeader(leader Leader) TimeWheelOption {
+	return leaderTimeWheelOption{leader}
+}
+
+func NewTimeWheel(interval time.Duration, slotNum int, opts ...TimeWheelOption) *timeWheel {
 	tw := &timeWheel{
 		interval:          interval,
+		clock:             SystemClock,
 		slots:             make([]*list.List, slotNum),
 		timer:             make(map[string]int),
 		cur:               -1,
@@ -47,18 +113,21 @@ func NewTimeWheel(interval time.Duration, slotNum int) *timeWheel {
 	for i := 0; i < tw.slotSum; i++ {
 		tw.slots[i] = list.New()
 	}
+	for _, o := range opts {
+		o.apply(tw)
+	}
 	return tw
 }
 
 func (tw *timeWheel) Start(ctx context.Context) {
-	tw.ticker = time.NewTicker(tw.interval)
+	tw.ticker = tw.clock.NewTicker(tw.interval)
 	go func() {
 		for {
 			select {
 			case <-ctx.Done():
 				tw.ticker.Stop()
 				return
-			case <-tw.ticker.C:
+			case <-tw.ticker.C():
 				tw.handler(ctx)
 			case task := <-tw.addTaskChannel:
 				tw.addTask(task)
@@ -99,6 +168,40 @@ func (tw *timeWheel) handler(ctx context.Context) {
 	tw.cur = (tw.cur + 1) % tw.slotSum
 	l := tw.slots[tw.cur]
 	tw.scanAndRunTask(ctx, l)
+	tw.fireDue(ctx)
+}
+
+// fireDue fires the due timers on the leader, the other replicas keep them for the failover window
+func (tw *timeWheel) fireDue(ctx context.Context) {
+	if len(tw.due) == 0 {
+		return
+	}
+	var failover time.Duration
+	if c, ok := tw.leader.(claimer); ok {
+		failover = c.failover()
+	}
+	now := tw.clock.Now()
+	leader := tw.leader.IsLeader()
+	kept := tw.due[:0]
+	for _, d := range tw.due {
+		switch {
+		case leader:
+			go tw.fire(ctx, d.DelayTask)
+		case now.Sub(d.since) < failover:
+			kept = append(kept, d)
+		}
+	}
+	tw.due = kept
+}
+
+// fire runs the callbacks of a due timer once the leader claimed it
+func (tw *timeWheel) fire(ctx context.Context, task DelayTask) {
+	if c, ok := tw.leader.(claimer); ok {
+		if claimed, err := c.claim(ctx, task.ID()); err != nil || !claimed {
+			return
+		}
+	}
+	tw.callbacks(ctx, task)
 }
 
 // 新增任务到链表中
@@ -107,10 +210,18 @@ func (tw *timeWheel) addTask(task *taskEntry) {
 	task.circle = circle
 	tw.slots[pos].PushBack(task)
 	tw.timer[task.ID()] = pos
+	task.SetState(Scheduled)
 }
 
 // // 从链表中删除任务
 func (tw *timeWheel) removeTask(id string) {
+	for i, d := range tw.due {
+		if d.ID() == id {
+			tw.due = append(tw.due[:i], tw.due[i+1:]...)
+			d.SetState(Cancelled)
+			break
+		}
+	}
 	// 获取定时器所在的槽
 	position, found := tw.timer[id]
 	if !found {
@@ -129,6 +240,7 @@ func (tw *timeWheel) removeTask(id string) {
 		if task.ID() == id {
 			delete(tw.timer, id)
 			l.Remove(e)
+			task.SetState(Cancelled)
 		}
 		e = e.Next()
 	}
@@ -155,7 +267,13 @@ func (tw *timeWheel) scanAndRunTask(ctx context.Context, l *list.List) {
 			e = e.Next()
 			continue
 		}
-		go tw.callbacks(ctx, task.Task)
+		if tw.leader == nil {
+			go tw.callbacks(ctx, task.DelayTask)
+		} else {
+			// 等待领导者触发
+			task.SetState(Pending)
+			tw.due = append(tw.due, &dueTimer{DelayTask: task.DelayTask, since: tw.clock.Now()})
+		}
 		next := e.Next()
 		l.Remove(e)
 		delete(tw.timer, task.ID())
@@ -163,9 +281,14 @@ func (tw *timeWheel) scanAndRunTask(ctx context.Context, l *list.List) {
 	}
 }
 
-func (tw *timeWheel) callbacks(ctx context.Context, task Task) {
+func (tw *timeWheel) callbacks(ctx context.Context, task DelayTask) {
+	task.SetState(Ready)
+	var err error
+	if task.Run {
+		err = task.Execute(ctx, nil)
+	}
 	for _, callback := range tw.callback {
-		callback.Trigger(ctx, task, nil, nil)
+		callback.Trigger(ctx, task.Task, nil, err)
 	}
 }
 
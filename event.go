@@ -0,0 +1,225 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventState EventType = "state"
+	EventError EventType = "error"
+
+	defaultEventBuffer = 1024
+)
+
+// Event is a state transition or an error recorded on an Info
+type Event struct {
+	ID           uint64            `json:"id"`
+	Type         EventType         `json:"type"`
+	InfoID       string            `json:"info_id"`
+	DefinitionID string            `json:"definition_id,omitempty"`
+	RootID       string            `json:"root_id"`
+	ExecutionID  string            `json:"execution_id,omitempty"`
+	Name         string            `json:"name"`
+	State        State             `json:"state"`
+	Error        string            `json:"error,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Time         time.Time         `json:"time"`
+}
+
+// EventHub fan out events to subscribers and keeps the latest ones in a ring buffer,
+// so that a subscriber can resume from the last event id it has seen
+type EventHub struct {
+	mutex       sync.RWMutex
+	seq         uint64
+	size        int
+	buffer      []Event // 环形缓冲区
+	start       int     // 最早事件所在下标
+	subscribers map[*Subscription]struct{}
+}
+
+func NewEventHub(size int) *EventHub {
+	if size <= 0 {
+		size = defaultEventBuffer
+	}
+	return &EventHub{
+		size:        size,
+		buffer:      make([]Event, 0, size),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Watch returns an Info that publish every SetState and AddError to the hub
+func (h *EventHub) Watch(info Info) Info {
+	for w, ok := info.(*watchedInfo); ok; w, ok = w.Info.(*watchedInfo) {
+		if w.hub == h {
+			return info
+		}
+	}
+	return &watchedInfo{Info: info, hub: h}
+}
+
+type eventHubKey struct{}
+
+// WatchContext returns a context whose runs publish to hub, the runs and TCC phases of the tasks
+// executed with it and of all their children
+func WatchContext(ctx context.Context, hub *EventHub) context.Context {
+	return context.WithValue(ctx, eventHubKey{}, hub)
+}
+
+// watch returns info watched by the hub of ctx, and a context passing the hub of a watched info
+// on to the children
+func watch(ctx context.Context, info Info) (context.Context, Info) {
+	if hub, ok := ctx.Value(eventHubKey{}).(*EventHub); ok && hub != nil {
+		info = hub.Watch(info)
+	}
+	if w, ok := info.(*watchedInfo); ok {
+		ctx = context.WithValue(ctx, eventHubKey{}, w.hub)
+	}
+	return ctx, info
+}
+
+// Publish assigns an id to the event, stores and broadcasts it
+func (h *EventHub) Publish(e Event) Event {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.seq++
+	e.ID = h.seq
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if len(h.buffer) < h.size {
+		h.buffer = append(h.buffer, e)
+	} else {
+		h.buffer[h.start] = e
+		h.start = (h.start + 1) % h.size
+	}
+	for s := range h.subscribers {
+		if !s.match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			// 订阅者消费过慢，关闭后由其通过 last event id 续传
+			h.remove(s)
+		}
+	}
+	return e
+}
+
+// Subscribe receives events of the given info, definition, root or execution id, or of all infos when id is empty.
+// Buffered events whose id is greater than lastEventID are delivered first.
+func (h *EventHub) Subscribe(id string, lastEventID uint64) *Subscription {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := &Subscription{hub: h, id: id}
+	backlog := make([]Event, 0)
+	for i := 0; i < len(h.buffer); i++ {
+		e := h.buffer[(h.start+i)%len(h.buffer)]
+		if e.ID > lastEventID && s.match(e) {
+			backlog = append(backlog, e)
+		}
+	}
+	s.ch = make(chan Event, len(backlog)+h.size)
+	for _, e := range backlog {
+		s.ch <- e
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+func (h *EventHub) remove(s *Subscription) {
+	if _, ok := h.subscribers[s]; !ok {
+		return
+	}
+	delete(h.subscribers, s)
+	close(s.ch)
+}
+
+type Subscription struct {
+	hub *EventHub
+	id  string
+	ch  chan Event
+}
+
+// Events is closed when the subscription is closed or falls behind
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *Subscription) Close() {
+	s.hub.mutex.Lock()
+	s.hub.remove(s)
+	s.hub.mutex.Unlock()
+}
+
+func (s *Subscription) match(e Event) bool {
+	return s.id == "" || s.id == e.InfoID || s.id == e.DefinitionID || s.id == e.RootID || s.id == e.ExecutionID
+}
+
+type watchedInfo struct {
+	Info
+	hub *EventHub
+}
+
+func (w *watchedInfo) SetState(state State) {
+	w.Info.SetState(state)
+	w.publish(EventState, nil)
+}
+
+func (w *watchedInfo) Transition(state State) error {
+	if err := w.Info.Transition(state); err != nil {
+		return err
+	}
+	w.publish(EventState, nil)
+	return nil
+}
+
+func (w *watchedInfo) AddError(err error, states ...bool) {
+	before := w.Info.State()
+	w.Info.AddError(err, states...)
+	if err != nil {
+		w.publish(EventError, err)
+	}
+	if w.Info.State() != before {
+		w.publish(EventState, nil)
+	}
+}
+
+func (w *watchedInfo) clearError() {
+	clearError(w.Info)
+}
+
+func (w *watchedInfo) addChild(child Info) {
+	addChild(w.Info, child)
+}
+
+func (w *watchedInfo) children() []Info {
+	return childrenOf(w.Info)
+}
+
+func (w *watchedInfo) NewRun() Info {
+	return w.hub.Watch(w.Info.NewRun())
+}
+
+func (w *watchedInfo) publish(typ EventType, err error) {
+	e := Event{
+		Type:         typ,
+		InfoID:       w.ID(),
+		DefinitionID: w.DefinitionID(),
+		RootID:       w.RootID(),
+		ExecutionID:  w.ExecutionID(),
+		Name:         w.Name(),
+		State:        w.State(),
+		Labels:       w.Labels(),
+		Time:         w.UpdateTime(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	w.hub.Publish(e)
+}
@@ -0,0 +1,100 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventHub(t *testing.T) {
+	hub := NewEventHub(16)
+	f := NewFunc(func(ctx context.Context, i interface{}) error {
+		return errors.New("failed")
+	}, WithEventHub(hub))
+	sub := hub.Subscribe(f.ID(), 0)
+	defer sub.Close()
+
+	assert.Error(t, f.Execute(context.Background(), nil))
+	expected := []State{Ready, Running, Error, Error}
+	for i, state := range expected {
+		e := <-sub.Events()
+		if i == 0 {
+			assert.Equal(t, f.ID(), e.InfoID)
+		} else {
+			// 每次执行的事件属于新的运行
+			assert.Equal(t, f.ID(), e.DefinitionID)
+		}
+		assert.Equal(t, state, e.State)
+	}
+
+	resumed := hub.Subscribe("", 2)
+	defer resumed.Close()
+	e := <-resumed.Events()
+	assert.Equal(t, uint64(3), e.ID)
+	assert.Equal(t, EventError, e.Type)
+	assert.Equal(t, "failed", e.Error)
+}
+
+func TestSSEHandler(t *testing.T) {
+	hub := NewEventHub(16)
+	info := hub.Watch(DefaultTaskInfo("sse"))
+	info.SetState(Running)
+	info.SetState(Success)
+
+	server := httptest.NewServer(SSEHandler(hub))
+	defer server.Close()
+	req, err := http.NewRequest(http.MethodGet, server.URL+"?id=sse", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	lines := make([]string, 0, 3)
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	assert.Equal(t, "id: 2", lines[0])
+	assert.Equal(t, "event: state", lines[1])
+	assert.Contains(t, lines[2], `"state":"success"`)
+}
+
+func TestWatchContext(t *testing.T) {
+	hub := NewEventHub(64)
+	first := NewFunc(func(ctx context.Context, i interface{}) error {
+		return nil
+	}, WithInfo(DefaultTaskInfo("first")))
+	tcc := NewTCC(first, first, first, WithInfo(DefaultTaskInfo("tcc")))
+	pipeline := NewTaskPipeline().WithTasks(first, NewTCCTask(tcc).Strict())
+	sub := hub.Subscribe("", 0)
+	defer sub.Close()
+
+	registry := NewRegistry(WithExecutionEvents(hub))
+	require.NoError(t, registry.Execute(context.Background(), "watched", pipeline, nil))
+
+	states := make(map[string][]State)
+	for len(sub.Events()) > 0 {
+		e := <-sub.Events()
+		assert.Equal(t, "watched", e.ExecutionID)
+		id := e.DefinitionID
+		if id == "" {
+			id = e.InfoID
+		}
+		states[id] = append(states[id], e.State)
+	}
+	// 子任务的每次运行和 TCC 的各阶段都会发布事件
+	assert.Equal(t, []State{Running, Success, Running, Success, Running, Success}, states["first"])
+	assert.Equal(t, []State{Trying, Confirming, Success}, states["tcc"])
+	assert.Equal(t, []State{Running, Success}, states[pipeline.ID()])
+}
@@ -0,0 +1,491 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+var (
+	ErrExecutionNotFound = errors.New("execution not found")
+	ErrExecutionExists   = errors.New("execution already running")
+)
+
+type executionKey struct{}
+
+type execution struct {
+	id        string
+	labels    map[string]string // 根节点的标签，用于查询
+	cancel    context.CancelFunc
+	cancelled int32
+	store     CheckpointStore
+	mutex     sync.Mutex
+	paused    bool
+	suspended bool          // 等待信号时已保存检查点并退出
+	wake      time.Time     // 挂起的信号中最早的超时时间
+	resume    chan struct{} // 恢复时关闭
+	steps     map[string]int
+	signals   map[string]json.RawMessage
+	waiters   map[string]chan json.RawMessage
+	pending   map[string]PendingSignal
+	history   *history
+}
+
+func (e *execution) save(ctx context.Context) error {
+	if e.store == nil {
+		return nil
+	}
+	e.mutex.Lock()
+	cp := &Checkpoint{
+		ExecutionID: e.id,
+		Paused:      e.paused,
+		Cancelled:   e.isCancelled(),
+		Steps:       make(map[string]int, len(e.steps)),
+		Signals:     make(map[string]json.RawMessage, len(e.signals)),
+		Pending:     make([]PendingSignal, 0, len(e.pending)),
+		UpdateTime:  time.Now(),
+	}
+	for k, v := range e.steps {
+		cp.Steps[k] = v
+	}
+	for k, v := range e.signals {
+		cp.Signals[k] = v
+	}
+	for _, p := range e.pending {
+		if _, ok := e.signals[p.Name]; !ok {
+			cp.Pending = append(cp.Pending, p)
+		}
+	}
+	e.mutex.Unlock()
+	return e.store.Save(ctx, cp)
+}
+
+func (e *execution) isCancelled() bool {
+	return atomic.LoadInt32(&e.cancelled) == 1
+}
+
+// ExecutionID returns the id of the execution started by a registry
+func ExecutionID(ctx context.Context) string {
+	if e, ok := ctx.Value(executionKey{}).(*execution); ok {
+		return e.id
+	}
+	return ""
+}
+
+// isCancelled reports whether the execution of ctx has been cancelled through its registry
+func isCancelled(ctx context.Context) bool {
+	e, ok := ctx.Value(executionKey{}).(*execution)
+	return ok && e.isCancelled()
+}
+
+// markCancelled set info to Cancelled when its execution has been cancelled while it ran,
+// the infos that finished before keep their outcome
+func markCancelled(ctx context.Context, info Info) {
+	if !isCancelled(ctx) {
+		return
+	}
+	switch info.State() {
+	case Success, Cancelled, Skipped, Compensated:
+		return
+	case Error:
+		if !isContextError(info.Error()) {
+			return
+		}
+	}
+	info.SetState(Cancelled)
+}
+
+// detachedContext keeps the values of its parent but is never done,
+// it lets compensation run after the execution has been cancelled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+type RegistryOption interface {
+	apply(*registry)
+}
+
+type checkpointRegistryOption struct {
+	store CheckpointStore
+}
+
+func (c checkpointRegistryOption) apply(r *registry) {
+	r.store = c.store
+}
+
+// WithCheckpointStore persist the position of executions at every step, executing a paused
+// or interrupted execution again after a restart resumes it from there
+func WithCheckpointStore(store CheckpointStore) RegistryOption {
+	return checkpointRegistryOption{store}
+}
+
+type historyRegistryOption struct {
+	store HistoryStore
+}
+
+func (h historyRegistryOption) apply(r *registry) {
+	r.history = h.store
+}
+
+// WithHistoryStore records the history of every execution, executing an execution again
+// replays the recorded steps instead of running them
+func WithHistoryStore(store HistoryStore) RegistryOption {
+	return historyRegistryOption{store}
+}
+
+type eventHubRegistryOption struct {
+	hub *EventHub
+}
+
+func (e eventHubRegistryOption) apply(r *registry) {
+	r.hub = e.hub
+}
+
+// WithExecutionEvents publishes to hub the state transitions and errors of every execution,
+// its children included
+func WithExecutionEvents(hub *EventHub) RegistryOption {
+	return eventHubRegistryOption{hub}
+}
+
+// NewRegistry returns a registry of the running executions, which can be cancelled,
+// paused and resumed by id
+func NewRegistry(opts ...RegistryOption) *registry {
+	r := &registry{
+		executions: make(map[string]*execution),
+		suspended:  make(map[string]*suspension),
+		workflows:  make(map[string]func() Task),
+	}
+	for _, o := range opts {
+		o.apply(r)
+	}
+	return r
+}
+
+type registry struct {
+	mutex      sync.Mutex
+	executions map[string]*execution
+	suspended  map[string]*suspension
+	workflows  map[string]func() Task
+	store      CheckpointStore
+	history    HistoryStore
+	hub        *EventHub
+}
+
+// suspension executes again an execution suspended by a signal task,
+// when the signal arrives or when the earliest deadline of its signals passed
+type suspension struct {
+	resume func() error
+	timer  *time.Timer
+}
+
+func (s *suspension) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+func (r *registry) start(ctx context.Context, id string, labels map[string]string) (context.Context, *execution,
+	error) {
+	e := &execution{
+		id:      id,
+		labels:  labels,
+		store:   r.store,
+		resume:  make(chan struct{}),
+		steps:   make(map[string]int),
+		signals: make(map[string]json.RawMessage),
+		waiters: make(map[string]chan json.RawMessage),
+		pending: make(map[string]PendingSignal),
+	}
+	ctx, e.cancel = context.WithCancel(ctx)
+	r.mutex.Lock()
+	if _, ok := r.executions[id]; ok {
+		r.mutex.Unlock()
+		e.cancel()
+		return nil, nil, fmt.Errorf("%w: %s", ErrExecutionExists, id)
+	}
+	r.executions[id] = e
+	if s, ok := r.suspended[id]; ok {
+		s.stop()
+		delete(r.suspended, id)
+	}
+	r.mutex.Unlock()
+	if err := r.load(ctx, e); err != nil {
+		r.mutex.Lock()
+		delete(r.executions, id)
+		r.mutex.Unlock()
+		e.cancel()
+		return nil, nil, err
+	}
+	if e.isCancelled() {
+		e.cancel()
+	}
+	if _, ok := ctx.Value(outputsKey{}).(*outputs); !ok {
+		ctx = WithOutputs(ctx)
+	}
+	if r.hub != nil {
+		ctx = WatchContext(ctx, r.hub)
+	}
+	return context.WithValue(ctx, executionKey{}, e), e, nil
+}
+
+// load restores the checkpoint and the history of e, e is already registered so that
+// the signals delivered meanwhile are kept
+func (r *registry) load(ctx context.Context, e *execution) error {
+	if r.store != nil {
+		cp, ok, err := r.store.Load(ctx, e.id)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if cp.Cancelled {
+				atomic.StoreInt32(&e.cancelled, 1)
+			}
+			e.mutex.Lock()
+			e.paused = cp.Paused
+			for k, v := range cp.Steps {
+				e.steps[k] = v
+			}
+			for k, v := range cp.Signals {
+				if _, ok := e.signals[k]; !ok {
+					e.signals[k] = v
+				}
+			}
+			for _, p := range cp.Pending {
+				e.pending[p.Name] = p
+			}
+			e.mutex.Unlock()
+		}
+	}
+	if r.history != nil {
+		events, err := r.history.Load(ctx, e.id)
+		if err != nil {
+			return err
+		}
+		e.history = newHistory(r.history, e.id, events)
+	}
+	return nil
+}
+
+// finish unregisters e, resume executes it again when it was suspended by a signal task
+func (r *registry) finish(ctx context.Context, e *execution, resume func() error) {
+	r.mutex.Lock()
+	if r.executions[e.id] == e {
+		delete(r.executions, e.id)
+	}
+	e.cancel()
+	e.mutex.Lock()
+	keep := e.paused || e.suspended
+	suspended := e.suspended && !e.isCancelled()
+	signalled := len(e.signals) > 0
+	wake := e.wake
+	e.mutex.Unlock()
+	if suspended {
+		// 持有注册表的锁保存，结束前收到的信号随检查点保存，之后的信号在检查点上投递
+		_ = e.save(detachedContext{ctx})
+		s := &suspension{resume: resume}
+		if !wake.IsZero() && !signalled {
+			s.timer = time.AfterFunc(time.Until(wake), func() { r.wake(e.id) })
+		}
+		r.suspended[e.id] = s
+	}
+	r.mutex.Unlock()
+	if suspended && signalled {
+		go r.wake(e.id)
+	}
+	// 暂停中因进程退出或等待信号而结束的执行保留检查点，以便之后恢复
+	if r.store != nil && (!keep || e.isCancelled()) {
+		_ = r.store.Delete(detachedContext{ctx}, e.id)
+	}
+}
+
+// wake executes again the suspended execution id
+func (r *registry) wake(id string) {
+	r.mutex.Lock()
+	s, ok := r.suspended[id]
+	delete(r.suspended, id)
+	r.mutex.Unlock()
+	if !ok {
+		return
+	}
+	s.stop()
+	// 结果通过回调和事件获取
+	_ = s.resume()
+}
+
+// Execute runs t as the execution id, the id of t is used when id is empty.
+// An execution suspended by a signal task returns ErrSuspended and runs again in the background
+// when the signal arrives or its timeout passed; after a restart it resumes when executed again.
+func (r *registry) Execute(ctx context.Context, id string, t Task, input interface{}, callbacks ...Callback) error {
+	if id == "" {
+		id = t.ID()
+	}
+	parent := detachedContext{ctx}
+	ctx, e, err := r.start(ctx, id, t.Labels())
+	if err != nil {
+		return err
+	}
+	defer r.finish(ctx, e, func() error { return r.Execute(parent, id, t, input, callbacks...) })
+	if ctx, err = e.history.root(ctx, t); err != nil {
+		return err
+	}
+	err = t.Execute(ctx, input, callbacks...)
+	return multierr.Append(err, e.history.finish(ctx, t, err))
+}
+
+// ExecuteTCC runs the Try of t as the execution id and then Confirm, or Cancel when Try failed.
+// Cancel also runs when the execution is cancelled, for the branches that already tried.
+// A Try suspended by a signal task returns ErrSuspended without Cancel, the execution
+// resumes like the ones of Execute.
+func (r *registry) ExecuteTCC(ctx context.Context, id string, t TCC, input interface{}, callbacks ...Callback) error {
+	if id == "" {
+		id = t.ID()
+	}
+	parent := detachedContext{ctx}
+	ctx, e, err := r.start(ctx, id, t.Labels())
+	if err != nil {
+		return err
+	}
+	defer r.finish(ctx, e, func() error { return r.ExecuteTCC(parent, id, t, input, callbacks...) })
+	t.SetExecutionID(id)
+	if ctx, err = e.history.root(ctx, t); err != nil {
+		return err
+	}
+	switch err = t.Try(ctx, input, callbacks...); {
+	case err == nil:
+		err = t.Confirm(ctx, input, callbacks...)
+	case errors.Is(err, ErrSuspended) && !isCancelled(ctx):
+	default:
+		err = multierr.Append(err, t.Cancel(detachedContext{ctx}, input, callbacks...))
+		markCancelled(ctx, t)
+	}
+	return multierr.Append(err, e.history.finish(ctx, t, err))
+}
+
+// Cancel stops the running execution id. An execution suspended by a signal task is marked cancelled
+// in the checkpoint store and executed again in the background, so that its TCCs are cancelled;
+// one suspended before a restart is cancelled when it is executed again.
+func (r *registry) Cancel(id string) error {
+	r.mutex.Lock()
+	e, ok := r.executions[id]
+	s, suspended := r.suspended[id]
+	delete(r.suspended, id)
+	r.mutex.Unlock()
+	if ok {
+		atomic.StoreInt32(&e.cancelled, 1)
+		e.cancel()
+		return nil
+	}
+	if suspended {
+		s.stop()
+	}
+	if r.store == nil {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	cp, ok, err := r.store.Load(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	cp.Cancelled = true
+	cp.UpdateTime = time.Now()
+	if err = r.store.Save(context.Background(), cp); err != nil {
+		return err
+	}
+	if suspended {
+		// 结果通过回调和事件获取
+		go func() { _ = s.resume() }()
+	}
+	return nil
+}
+
+// Pause stops the execution id at the next step boundary of its pipelines
+func (r *registry) Pause(id string) error {
+	r.mutex.Lock()
+	e, ok := r.executions[id]
+	r.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	e.mutex.Lock()
+	if !e.paused {
+		e.paused = true
+		e.resume = make(chan struct{})
+	}
+	e.mutex.Unlock()
+	return nil
+}
+
+// Resume continues the paused execution id.
+// An execution paused before a restart is marked resumed in the checkpoint store,
+// it continues when it is executed again.
+func (r *registry) Resume(id string) error {
+	r.mutex.Lock()
+	e, ok := r.executions[id]
+	r.mutex.Unlock()
+	if ok {
+		e.mutex.Lock()
+		if e.paused {
+			e.paused = false
+			close(e.resume)
+		}
+		e.mutex.Unlock()
+		return e.save(context.Background())
+	}
+	if r.store == nil {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	cp, ok, err := r.store.Load(context.Background(), id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	cp.Paused = false
+	cp.UpdateTime = time.Now()
+	return r.store.Save(context.Background(), cp)
+}
+
+// Running returns the ids of the running executions
+func (r *registry) Running() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := make([]string, 0, len(r.executions))
+	for id := range r.executions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// RunningWith returns the ids of the running executions whose root has every label of selector
+func (r *registry) RunningWith(selector map[string]string) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ids := make([]string, 0)
+	for id, e := range r.executions {
+		if matchLabels(e.labels, selector) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,205 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func blockUntilDone(ctx context.Context, _ interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// awaitState waits for an event of the subscription in state
+func awaitState(t *testing.T, sub *Subscription, state State) Event {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case e := <-sub.Events():
+			if e.State == state {
+				return e
+			}
+		case <-timeout:
+			t.Fatalf("no event in state %s", state)
+		}
+	}
+}
+
+func TestRegistryCancel(t *testing.T) {
+	r := NewRegistry()
+	var executed int32
+	var firstRun, pipelineRun Info
+	first := NewFunc(blockUntilDone, WithCallbacks(recordRun(&firstRun)))
+	second := NewFunc(func(ctx context.Context, i interface{}) error {
+		atomic.AddInt32(&executed, 1)
+		return nil
+	})
+	pipeline := NewTaskPipeline(WithCallbacks(recordRun(&pipelineRun))).WithTasks(first, second)
+
+	done := make(chan error)
+	go func() { done <- r.Execute(context.Background(), "exec-1", pipeline, nil) }()
+	assert.Eventually(t, func() bool { return len(r.Running()) == 1 }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, r.Execute(context.Background(), "exec-1", pipeline, nil), ErrExecutionExists)
+
+	assert.NoError(t, r.Cancel("exec-1"))
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, Cancelled, pipelineRun.State())
+	assert.Equal(t, Cancelled, firstRun.State())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&executed))
+	assert.ErrorIs(t, r.Cancel("exec-1"), ErrExecutionNotFound)
+}
+
+func TestRegistryCancelTCC(t *testing.T) {
+	r := NewRegistry()
+	var cancelled int32
+	undo := NewFunc(func(ctx context.Context, i interface{}) error {
+		if ctx.Err() != nil {
+			return errors.New("cancel ran with a done context")
+		}
+		atomic.AddInt32(&cancelled, 1)
+		return nil
+	})
+	tried := NewTCC(NewFunc(UI), NewFunc(UI), undo)
+	blocked := NewTCC(NewFunc(blockUntilDone), NewFunc(UI), NewFunc(UI))
+	pipeline := NewTCCPipeline().WithTCCs(tried, blocked)
+
+	done := make(chan error)
+	go func() { done <- r.ExecuteTCC(context.Background(), "", pipeline, nil) }()
+	assert.Eventually(t, func() bool { return len(r.Running()) == 1 }, time.Second, time.Millisecond)
+	assert.NoError(t, r.Cancel(pipeline.ID()))
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cancelled))
+	assert.Equal(t, Cancelled, pipeline.State())
+	assert.Equal(t, Cancelled, tried.State())
+}
+
+func TestRegistryPauseResume(t *testing.T) {
+	store := NewFileCheckpointStore(t.TempDir())
+	var counts [3]int32
+	gate := make(chan struct{})
+	hub := NewEventHub(0)
+	newPipeline := func() Task {
+		tasks := make([]Task, 0, len(counts))
+		for i := range counts {
+			i := i
+			tasks = append(tasks, NewFunc(func(ctx context.Context, _ interface{}) error {
+				atomic.AddInt32(&counts[i], 1)
+				if i == 0 {
+					<-gate
+				}
+				return nil
+			}))
+		}
+		return NewTaskPipeline(WithInfo(DefaultTaskInfo("pipeline")), WithEventHub(hub)).WithTasks(tasks...)
+	}
+
+	r := NewRegistry(WithCheckpointStore(store))
+	pipeline := newPipeline()
+	sub := hub.Subscribe("pipeline", 0)
+	defer sub.Close()
+	ctx, shutdown := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.Execute(ctx, "exec-1", pipeline, nil) }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&counts[0]) == 1 }, time.Second, time.Millisecond)
+	assert.NoError(t, r.Pause("exec-1"))
+	close(gate)
+	awaitState(t, sub, Paused)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&counts[1]))
+
+	// 进程退出后暂停位置仍然保留
+	shutdown()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	cp, ok, err := store.Load(context.Background(), "exec-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, cp.Paused)
+	assert.Equal(t, map[string]int{"pipeline": 1}, cp.Steps)
+
+	r = NewRegistry(WithCheckpointStore(store))
+	assert.NoError(t, r.Resume("exec-1"))
+	assert.NoError(t, r.Execute(context.Background(), "exec-1", newPipeline(), nil))
+	assert.Equal(t, [3]int32{1, 1, 1}, counts)
+	_, ok, err = store.Load(context.Background(), "exec-1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestRegistryCancelKeepsFinished(t *testing.T) {
+	r := NewRegistry()
+	var firstRun, pipelineRun Info
+	started := make(chan struct{})
+	// 不响应取消、正常完成的任务
+	first := NewFunc(func(ctx context.Context, i interface{}) error {
+		close(started)
+		<-ctx.Done()
+		return nil
+	}, WithCallbacks(recordRun(&firstRun)))
+	pipeline := NewTaskPipeline(WithCallbacks(recordRun(&pipelineRun))).WithTasks(first, NewFunc(UI))
+
+	done := make(chan error)
+	go func() { done <- r.Execute(context.Background(), "exec-1", pipeline, nil) }()
+	<-started
+	assert.NoError(t, r.Cancel("exec-1"))
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, Success, firstRun.State())
+	assert.Equal(t, Cancelled, pipelineRun.State())
+}
+
+func TestRegistryCheckpointEveryStep(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	started := make(chan struct{})
+	pipeline := NewTaskPipeline(WithInfo(DefaultTaskInfo("pipeline"))).WithTasks(NewFunc(UI),
+		NewFunc(func(ctx context.Context, i interface{}) error {
+			close(started)
+			return blockUntilDone(ctx, i)
+		}))
+	r := NewRegistry(WithCheckpointStore(store))
+	done := make(chan error)
+	go func() { done <- r.Execute(context.Background(), "exec-1", pipeline, nil) }()
+	<-started
+	// 运行中的执行同样保存了位置，进程退出后从该步骤恢复
+	cp, ok, err := store.Load(context.Background(), "exec-1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]int{"pipeline": 1}, cp.Steps)
+	assert.NoError(t, r.Cancel("exec-1"))
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestRegistryResumeTCCPipeline(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	// 之前的进程 Try 了前两个分支后暂停
+	assert.NoError(t, store.Save(context.Background(), &Checkpoint{
+		ExecutionID: "exec-1",
+		Paused:      true,
+		Steps:       map[string]int{"pipeline": 2},
+	}))
+	var tries, cancels [3]int32
+	tccs := make([]TCC, 0, len(tries))
+	for i := range tries {
+		i := i
+		tccs = append(tccs, NewTCC(NewFunc(func(ctx context.Context, _ interface{}) error {
+			atomic.AddInt32(&tries[i], 1)
+			return nil
+		}), NewFunc(UI), NewFunc(func(ctx context.Context, _ interface{}) error {
+			atomic.AddInt32(&cancels[i], 1)
+			return nil
+		})))
+	}
+	pipeline := NewTCCPipeline(WithInfo(DefaultTaskInfo("pipeline"))).WithTCCs(tccs...)
+
+	r := NewRegistry(WithCheckpointStore(store))
+	done := make(chan error)
+	go func() { done <- r.ExecuteTCC(context.Background(), "exec-1", pipeline, nil) }()
+	assert.Eventually(t, func() bool { return pipeline.State() == Paused }, time.Second, time.Millisecond)
+	// 恢复前取消，之前进程 Try 过的分支也要回滚
+	assert.NoError(t, r.Cancel("exec-1"))
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.Equal(t, [3]int32{0, 0, 0}, tries)
+	assert.Equal(t, [3]int32{1, 1, 0}, cancels)
+}
@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTCCExpired is returned by a Confirm arriving after the TCC was cancelled on expiry
+var ErrTCCExpired = errors.New("tcc expired before it was confirmed")
+
+// WithExpiry cancels a tried TCC when neither Confirm nor Cancel arrives within timeout, the expiry timers of
+// its global transactions are kept in wheel, which must be started. A Try called without a global transaction
+// starts one (see WithTransactionID), its Confirm and Cancel called without one join it.
+// An expired transaction rejects its Confirm with ErrTCCExpired until its Cancel ends it. The deadlines follow
+// the clock of wheel (see WithTimeWheelClock) and live in this process only: a TCC tried before a restart is not cancelled on expiry,
+// the coordinator must Cancel it (see WithBarrier for the late phases).
+func WithExpiry(wheel *timeWheel, timeout time.Duration) Option {
+	return expiryOption{wheel: wheel, timeout: timeout}
+}
+
+type expiryOption struct {
+	wheel   *timeWheel
+	timeout time.Duration
+}
+
+func (e expiryOption) apply(opts *options) {
+	opts.expiryWheel = e.wheel
+	opts.expiryTimeout = e.timeout
+}
+
+type expiryKey struct{}
+
+// expiry drives the Cancel of the tried TCCs whose deadline passed
+type expiry struct {
+	wheel   *timeWheel
+	timeout time.Duration
+	mutex   sync.Mutex
+	tried   map[string]*expiryEntry // 全局事务/TCC -> 定时器，过期后作为墓碑保留到 Cancel
+}
+
+// expiryEntry is the timer of one Try, expired is set when its Cancel starts
+type expiryEntry struct {
+	expired time.Time
+}
+
+func newExpiry(opt *options) *expiry {
+	if opt.expiryWheel == nil {
+		return nil
+	}
+	return &expiry{
+		wheel:   opt.expiryWheel,
+		timeout: opt.expiryTimeout,
+		tried:   make(map[string]*expiryEntry),
+	}
+}
+
+func expiryTimerID(gid string, tcc Info) string {
+	return fmt.Sprintf("%s/%s", gid, tcc.ID())
+}
+
+// start schedules the Cancel of tcc in the global transaction of ctx
+func (e *expiry) start(ctx context.Context, tcc TCC, input interface{}) {
+	gid := TransactionID(ctx)
+	if e == nil || gid == "" {
+		return
+	}
+	id := expiryTimerID(gid, tcc)
+	e.mutex.Lock()
+	if entry, ok := e.tried[id]; ok && entry.expired.IsZero() {
+		e.mutex.Unlock()
+		return
+	}
+	// 过期后的 Try 重新计时
+	entry := &expiryEntry{}
+	e.tried[id] = entry
+	e.mutex.Unlock()
+	info := DefaultTaskInfo(id)
+	info.SetName("tcc-expiry")
+	task := NewFunc(func(ctx context.Context, _ interface{}) error {
+		e.mutex.Lock()
+		tried := e.tried[id] == entry && entry.expired.IsZero()
+		if tried {
+			entry.expired = e.wheel.clock.Now()
+		}
+		e.mutex.Unlock()
+		if !tried {
+			return nil
+		}
+		ctx = context.WithValue(WithTransactionID(detachedContext{ctx}, gid), expiryKey{}, true)
+		return tcc.Cancel(ctx, input)
+	}, WithInfo(info))
+	e.wheel.AddTimer(DelayTask{Delay: e.timeout, Run: true, Task: task})
+}
+
+// stop removes the expiry timer of tcc before phase, run is false when the phase must be skipped
+// because the TCC already expired
+func (e *expiry) stop(ctx context.Context, tcc Info, phase Phase) (run bool, err error) {
+	gid := TransactionID(ctx)
+	if e == nil || gid == "" || ctx.Value(expiryKey{}) != nil {
+		return true, nil
+	}
+	id := expiryTimerID(gid, tcc)
+	e.mutex.Lock()
+	entry, ok := e.tried[id]
+	// 过期的墓碑保留到 Cancel，期间的 Confirm 都被拒绝
+	if ok && (entry.expired.IsZero() || phase != PhaseConfirm) {
+		delete(e.tried, id)
+	}
+	e.mutex.Unlock()
+	switch {
+	case !ok:
+		return true, nil
+	case entry.expired.IsZero():
+		e.wheel.RemoveTimer(id)
+		return true, nil
+	case phase == PhaseConfirm:
+		return false, fmt.Errorf("%w: %s %s", ErrTCCExpired, gid, tcc.ID())
+	default:
+		// 已在过期时取消
+		return false, nil
+	}
+}
@@ -0,0 +1,89 @@
+package workflow
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var fired int32
+	tw := NewTimeWheel(10*time.Millisecond, 10, WithTimerCallbacks(
+		callbackFunc(func(_ context.Context, _ Info, _ interface{}, err error) {
+			assert.NoError(t, err)
+			atomic.AddInt32(&fired, 1)
+		})))
+	tw.Start(ctx)
+
+	var confirms, cancels int32
+	tcc := NewTCC(NewFunc(UI), failingTask(0, &confirms), failingTask(0, &cancels),
+		WithExpiry(tw, 30*time.Millisecond))
+
+	// 协调者未到达时自动取消
+	expired := WithTransactionID(context.Background(), "gid-1")
+	require.NoError(t, tcc.Try(expired, nil))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancels) == 1 }, time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&fired) == 1 }, time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, tcc.Confirm(expired, nil), ErrTCCExpired)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&confirms))
+
+	cancelled := WithTransactionID(context.Background(), "gid-2")
+	require.NoError(t, tcc.Try(cancelled, nil))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancels) == 2 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, tcc.Cancel(cancelled, nil))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cancels))
+
+	// 正常结束时删除定时器
+	confirmed := WithTransactionID(context.Background(), "gid-3")
+	require.NoError(t, tcc.Try(confirmed, nil))
+	require.NoError(t, tcc.Confirm(confirmed, nil))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&confirms))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&cancels))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fired))
+}
+
+func TestExpiryRetried(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tw := NewTimeWheel(10*time.Millisecond, 10)
+	tw.Start(ctx)
+
+	var confirms, cancels int32
+	tcc := NewTCC(NewFunc(UI), failingTask(0, &confirms), failingTask(0, &cancels),
+		WithExpiry(tw, 30*time.Millisecond))
+	expired := WithTransactionID(context.Background(), "gid-1")
+	require.NoError(t, tcc.Try(expired, nil))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancels) == 1 }, time.Second, 5*time.Millisecond)
+
+	// 过期后再次 Try 重新计时
+	require.NoError(t, tcc.Try(expired, nil))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancels) == 2 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, tcc.Try(expired, nil))
+	require.NoError(t, tcc.Confirm(expired, nil))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&confirms))
+
+	// 过期记录保留到事务的 Cancel，超时之后的 Confirm 仍被拒绝
+	other := WithTransactionID(context.Background(), "gid-2")
+	require.NoError(t, tcc.Try(other, nil))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancels) == 3 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.ErrorIs(t, tcc.Confirm(other, nil), ErrTCCExpired)
+	assert.ErrorIs(t, tcc.Confirm(other, nil), ErrTCCExpired)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&confirms))
+	expiry := tcc.(*simpleTCC).expiry
+	expiry.mutex.Lock()
+	assert.Len(t, expiry.tried, 1)
+	expiry.mutex.Unlock()
+	require.NoError(t, tcc.Cancel(other, nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&cancels))
+	expiry.mutex.Lock()
+	assert.Empty(t, expiry.tried)
+	expiry.mutex.Unlock()
+}
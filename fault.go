@@ -0,0 +1,261 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var ErrInjected = errors.New("injected fault")
+
+type FaultKind string
+
+const (
+	FaultError FaultKind = "error"
+	// FaultPanic panics inside the call, the panic is recovered as an error of the task like NewFunc does
+	FaultPanic FaultKind = "panic"
+	// FaultLatency delays the call by Latency, unless the context is done first
+	FaultLatency FaultKind = "latency"
+	// FaultHang blocks the call ignoring its context until the injector releases it
+	FaultHang FaultKind = "hang"
+)
+
+// Phase is the method of a task or TCC a fault is injected into
+type Phase string
+
+const (
+	PhaseExecute Phase = "execute"
+	PhaseTry     Phase = "try"
+	PhaseConfirm Phase = "confirm"
+	PhaseCancel  Phase = "cancel"
+)
+
+// FaultRule injects Kind into the calls it matches, all its conditions must hold
+type FaultRule struct {
+	// Name is a path.Match pattern on the name of the task or TCC, empty matches every name
+	Name string
+	// Phases matched, empty matches every phase
+	Phases []Phase
+	// Calls are the numbers of the calls of a task and phase, counted from 1, empty matches every call
+	Calls []int
+	// Probability of injecting into a matched call, 0 always injects
+	Probability float64
+	Kind        FaultKind
+	// Err is returned by FaultError, ErrInjected when nil
+	Err     error
+	Latency time.Duration
+}
+
+func (r *FaultRule) match(name string, phase Phase, call int) bool {
+	if r.Name != "" {
+		if ok, _ := path.Match(r.Name, name); !ok {
+			return false
+		}
+	}
+	if len(r.Phases) > 0 && !containsPhase(r.Phases, phase) {
+		return false
+	}
+	if len(r.Calls) == 0 {
+		return true
+	}
+	for _, c := range r.Calls {
+		if c == call {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPhase(phases []Phase, phase Phase) bool {
+	for _, p := range phases {
+		if p == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// NewFaultInjector returns an injector without rules, seed makes the probabilities reproducible
+func NewFaultInjector(seed int64) *FaultInjector {
+	return &FaultInjector{
+		calls:   make(map[string]int),
+		rand:    rand.New(rand.NewSource(seed)),
+		release: make(chan struct{}),
+	}
+}
+
+// FaultInjector decides the faults of the tasks and TCCs wrapped by FaultTask and FaultTCC,
+// its rules can be changed while they run
+type FaultInjector struct {
+	mutex   sync.Mutex
+	rules   []FaultRule
+	calls   map[string]int // id:phase -> 调用次数
+	rand    *rand.Rand
+	release chan struct{}
+}
+
+// Set replaces the rules, the first matching rule applies
+func (f *FaultInjector) Set(rules ...FaultRule) {
+	f.mutex.Lock()
+	f.rules = append([]FaultRule(nil), rules...)
+	f.mutex.Unlock()
+}
+
+func (f *FaultInjector) Add(rule FaultRule) {
+	f.mutex.Lock()
+	f.rules = append(f.rules, rule)
+	f.mutex.Unlock()
+}
+
+// Clear removes the rules and releases the hanging calls
+func (f *FaultInjector) Clear() {
+	f.mutex.Lock()
+	f.rules = nil
+	close(f.release)
+	f.release = make(chan struct{})
+	f.mutex.Unlock()
+}
+
+func (f *FaultInjector) fault(info Info, phase Phase) (*FaultRule, <-chan struct{}) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	key := fmt.Sprintf("%s:%s", info.ID(), phase)
+	f.calls[key]++
+	for i := range f.rules {
+		rule := f.rules[i]
+		if !rule.match(info.Name(), phase, f.calls[key]) {
+			continue
+		}
+		if rule.Probability > 0 && f.rand.Float64() >= rule.Probability {
+			return nil, nil
+		}
+		return &rule, f.release
+	}
+	return nil, nil
+}
+
+// inject applies the fault chosen for the call, call runs unless the fault fails it
+func (f *FaultInjector) inject(ctx context.Context, info Info, phase Phase, call func() error) (err error) {
+	rule, release := f.fault(info, phase)
+	if rule == nil {
+		return call()
+	}
+	switch rule.Kind {
+	case FaultError:
+		if rule.Err != nil {
+			return rule.Err
+		}
+		return fmt.Errorf("%w: %s %s", ErrInjected, info.Name(), phase)
+	case FaultPanic:
+		defer func() {
+			if r := recover(); r != nil {
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				err = fmt.Errorf("[Recover] found:%v,trace:\n%s", r, buf)
+			}
+		}()
+		panic(fmt.Sprintf("%s: %s %s", ErrInjected, info.Name(), phase))
+	case FaultLatency:
+		timer := time.NewTimer(rule.Latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	case FaultHang:
+		// 忽略 ctx，模拟不响应取消的调用
+		<-release
+	}
+	return call()
+}
+
+// FaultTask injects the faults of injector into the runs of t, it shares the Info of t.
+// A call that is not failed by a fault is a run of t only.
+func FaultTask(t Task, injector *FaultInjector) Task {
+	return &faultTask{
+		Task:     t,
+		injector: injector,
+	}
+}
+
+type faultTask struct {
+	Task
+	injector *FaultInjector
+}
+
+func (f *faultTask) children() []Info {
+	return childrenOf(f.Task)
+}
+
+func (f *faultTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	injected := true
+	err := f.injector.inject(ctx, f, PhaseExecute, func() error {
+		injected = false
+		return f.Task.Execute(ctx, input, callbacks...)
+	})
+	if injected {
+		// 未调用任务时由一次运行记录注入的故障，否则运行属于任务本身
+		ctx, info := startRun(ctx, f)
+		info.SetState(Running)
+		info.AddError(err)
+		markCancelled(ctx, info)
+		for _, callback := range callbacks {
+			callback.Trigger(ctx, info, input, err)
+		}
+	}
+	return err
+}
+
+// FaultTCC injects the faults of injector into the phases of t, it shares the Info of t
+func FaultTCC(t TCC, injector *FaultInjector) TCC {
+	return &faultTCC{
+		TCC:      t,
+		injector: injector,
+	}
+}
+
+type faultTCC struct {
+	TCC
+	injector *FaultInjector
+}
+
+func (f *faultTCC) children() []Info {
+	return childrenOf(f.TCC)
+}
+
+func (f *faultTCC) Try(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	return f.phase(ctx, PhaseTry, input, callbacks, f.TCC.Try)
+}
+
+func (f *faultTCC) Confirm(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	return f.phase(ctx, PhaseConfirm, input, callbacks, f.TCC.Confirm)
+}
+
+func (f *faultTCC) Cancel(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	return f.phase(ctx, PhaseCancel, input, callbacks, f.TCC.Cancel)
+}
+
+func (f *faultTCC) phase(ctx context.Context, phase Phase, input interface{}, callbacks []Callback,
+	call func(context.Context, interface{}, ...Callback) error) error {
+	injected := true
+	err := f.injector.inject(ctx, f, phase, func() error {
+		injected = false
+		return call(ctx, input, callbacks...)
+	})
+	if injected {
+		// 未调用 TCC 时由此记录错误
+		f.AddError(err, phase != PhaseTry)
+		markCancelled(ctx, f)
+		for _, callback := range callbacks {
+			callback.Trigger(ctx, f.TCC, input, err)
+		}
+	}
+	return err
+}
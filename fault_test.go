@@ -0,0 +1,112 @@
+package workflow
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countingTCC(name string, tried, cancelled *int32) TCC {
+	tcc := NewTCC(
+		NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(tried, 1)
+			return nil
+		}),
+		NewFunc(UI),
+		NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(cancelled, 1)
+			return nil
+		}),
+	)
+	tcc.SetName(name)
+	return tcc
+}
+
+func TestFaultTCCCompensation(t *testing.T) {
+	injector := NewFaultInjector(1)
+	var tried, cancelled int32
+	pipeline := NewTCCPipeline().WithTCCs(
+		FaultTCC(countingTCC("pay", &tried, &cancelled), injector),
+		FaultTCC(countingTCC("reserve", &tried, &cancelled), injector),
+		FaultTCC(countingTCC("ship-eu", &tried, &cancelled), injector),
+	)
+	injector.Set(FaultRule{Name: "ship-*", Phases: []Phase{PhaseTry}, Kind: FaultError})
+
+	err := pipeline.Try(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrInjected)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&tried))
+	require.Error(t, pipeline.Cancel(context.Background(), nil))
+	// 失败的分支与之前的分支都被补偿
+	assert.Equal(t, int32(3), atomic.LoadInt32(&cancelled))
+}
+
+func TestFaultTask(t *testing.T) {
+	injector := NewFaultInjector(1)
+	var executed int32
+	task := FaultTask(NewFunc(func(context.Context, interface{}) error {
+		atomic.AddInt32(&executed, 1)
+		return nil
+	}), injector)
+	injector.Set(FaultRule{Calls: []int{2}, Kind: FaultPanic})
+
+	assert.NoError(t, task.Execute(context.Background(), nil))
+	run, err := Run(context.Background(), task, nil)
+	assert.Contains(t, err.Error(), "[Recover]")
+	assert.Equal(t, Error, run.State())
+	assert.NoError(t, task.Execute(context.Background(), nil))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&executed))
+
+	// 按概率注入
+	injector.Set(FaultRule{Probability: 0.5, Kind: FaultError})
+	failed := 0
+	for i := 0; i < 100; i++ {
+		if task.Execute(context.Background(), nil) != nil {
+			failed++
+		}
+	}
+	assert.InDelta(t, 50, failed, 20)
+}
+
+func TestFaultTaskSingleRun(t *testing.T) {
+	injector := NewFaultInjector(1)
+	task := FaultTask(NewFunc(UI), injector)
+	hub := NewEventHub(64)
+	sub := hub.Subscribe(task.ID(), 0)
+	defer sub.Close()
+	ctx := WatchContext(context.Background(), hub)
+
+	require.NoError(t, task.Execute(ctx, nil))
+	injector.Set(FaultRule{Kind: FaultError})
+	assert.ErrorIs(t, task.Execute(ctx, nil), ErrInjected)
+
+	// 每次调用只有一次运行
+	runs := make(map[string]bool)
+	for len(sub.Events()) > 0 {
+		runs[(<-sub.Events()).InfoID] = true
+	}
+	assert.Len(t, runs, 2)
+}
+
+func TestFaultHang(t *testing.T) {
+	injector := NewFaultInjector(1)
+	task := FaultTask(NewFunc(UI), injector)
+	injector.Set(FaultRule{Kind: FaultLatency, Latency: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, task.Execute(ctx, nil), context.DeadlineExceeded)
+
+	injector.Set(FaultRule{Kind: FaultHang})
+	done := make(chan error)
+	go func() { done <- task.Execute(ctx, nil) }()
+	select {
+	case <-done:
+		t.Fatal("hang returned when its context was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+	injector.Clear()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NewFileLocker returns a Locker keeping one lease file per key in dir,
+// it suits replicas sharing a host or a network file system.
+// The read-modify-write of the leases is serialized by a mutex file in dir, flocked on unix systems.
+func NewFileLocker(dir string) *fileLocker {
+	return &fileLocker{dir: dir}
+}
+
+type fileLocker struct {
+	dir    string
+	pruner pruner
+}
+
+// mutex is the path of the mutex file serializing the leases of dir
+func (f *fileLocker) mutex() string {
+	return filepath.Join(f.dir, "leases.mutex")
+}
+
+func (f *fileLocker) path(key string) string {
+	return filepath.Join(f.dir, url.PathEscape(key)+".lease")
+}
+
+func (f *fileLocker) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	path := f.path(key)
+	unlock, err := f.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	now := time.Now()
+	if f.pruner.due(now) {
+		if err = f.prune(now); err != nil {
+			return false, err
+		}
+	}
+	l, err := readLease(path)
+	if err != nil {
+		return false, err
+	}
+	if l.owner != "" && l.owner != owner && now.Before(l.deadline) {
+		return false, nil
+	}
+	return true, writeLease(path, lease{owner: owner, deadline: now.Add(ttl)})
+}
+
+func (f *fileLocker) Release(ctx context.Context, key, owner string) error {
+	path := f.path(key)
+	unlock, err := f.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	l, err := readLease(path)
+	if err != nil || l.owner != owner {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// prune removes the lease files expired at now, the caller holds the mutex
+func (f *fileLocker) prune(now time.Time) error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".lease") {
+			continue
+		}
+		path := filepath.Join(f.dir, entry.Name())
+		// 无法解析的租约文件保留
+		if l, err := readLease(path); err == nil && !now.Before(l.deadline) {
+			if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readLease(path string) (lease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return lease{}, nil
+		}
+		return lease{}, err
+	}
+	parts := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	if len(parts) != 2 {
+		return lease{}, fmt.Errorf("invalid lease file %s", path)
+	}
+	deadline, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return lease{}, fmt.Errorf("invalid lease file %s: %w", path, err)
+	}
+	return lease{owner: parts[0], deadline: time.Unix(0, deadline)}, nil
+}
+
+func writeLease(path string, l lease) error {
+	temp := path + ".tmp"
+	data := fmt.Sprintf("%s\n%d\n", l.owner, l.deadline.UnixNano())
+	if err := os.WriteFile(temp, []byte(data), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
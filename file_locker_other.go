@@ -0,0 +1,52 @@
+//go:build !unix
+
+package workflow
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const staleFileMutex = 10 * time.Second
+
+// lock serializes the read-modify-write of the leases with an exclusively created mutex file
+func (f *fileLocker) lock(ctx context.Context) (func(), error) {
+	mutex := f.mutex()
+	for {
+		file, err := os.OpenFile(mutex, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_ = file.Close()
+			return func() { _ = os.Remove(mutex) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		// 持有者异常退出时遗留的互斥文件
+		if stat, err := os.Stat(mutex); err == nil && time.Since(stat.ModTime()) > staleFileMutex {
+			breakStale(mutex, stat)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// breakStale removes the stale mutex file. It is first moved aside, so that a mutex file another
+// replica created in the meantime is put back instead of being removed.
+func breakStale(mutex string, stale os.FileInfo) {
+	aside := mutex + "." + uuid.NewV4().String()
+	if err := os.Rename(mutex, aside); err != nil {
+		return
+	}
+	if moved, err := os.Stat(aside); err == nil && !os.SameFile(moved, stale) {
+		_ = os.Link(aside, mutex)
+	}
+	_ = os.Remove(aside)
+}
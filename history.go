@@ -0,0 +1,483 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type HistoryEventType string
+
+const (
+	HistoryScheduled HistoryEventType = "scheduled"
+	HistoryStarted   HistoryEventType = "started"
+	HistoryCompleted HistoryEventType = "completed"
+	HistoryFailed    HistoryEventType = "failed"
+	HistoryRetried   HistoryEventType = "retried"
+	HistoryTried     HistoryEventType = "tried"
+	HistoryConfirmed HistoryEventType = "confirmed"
+	HistoryCancelled HistoryEventType = "cancelled"
+
+	rootStep = "0"
+)
+
+var ErrNonDeterministic = errors.New("execution does not match its history")
+
+// HistoryEvent is an entry of the append-only history of an execution.
+// Step is the position of the info in the execution tree ("0" is the root, "0/2" its third child),
+// Run counts the events of the same type on the step. The outcome of a run holds the outputs
+// it recorded with SetOutput, they are restored as json.RawMessage when it is replayed.
+type HistoryEvent struct {
+	Step    string                     `json:"step"`
+	Run     int                        `json:"run"`
+	Type    HistoryEventType           `json:"type"`
+	InfoID  string                     `json:"info_id"`
+	Name    string                     `json:"name"`
+	Error   string                     `json:"error,omitempty"`
+	Outputs map[string]json.RawMessage `json:"outputs,omitempty"`
+	Time    time.Time                  `json:"time"`
+}
+
+func (e *HistoryEvent) key() string {
+	return fmt.Sprintf("%s:%s#%d", e.Step, e.Type, e.Run)
+}
+
+func (e *HistoryEvent) err() error {
+	if e.Error == "" {
+		return nil
+	}
+	return errors.New(e.Error)
+}
+
+// HistoryStore keeps the history of executions
+type HistoryStore interface {
+	Append(ctx context.Context, executionID string, events ...HistoryEvent) error
+	Load(ctx context.Context, executionID string) ([]HistoryEvent, error)
+}
+
+// NewMemoryHistoryStore returns a HistoryStore kept in process memory
+func NewMemoryHistoryStore() *memoryHistoryStore {
+	return &memoryHistoryStore{
+		histories: make(map[string][]HistoryEvent),
+	}
+}
+
+type memoryHistoryStore struct {
+	mutex     sync.RWMutex
+	histories map[string][]HistoryEvent
+}
+
+func (m *memoryHistoryStore) Append(_ context.Context, executionID string, events ...HistoryEvent) error {
+	m.mutex.Lock()
+	m.histories[executionID] = append(m.histories[executionID], events...)
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *memoryHistoryStore) Load(_ context.Context, executionID string) ([]HistoryEvent, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]HistoryEvent(nil), m.histories[executionID]...), nil
+}
+
+// NewFileHistoryStore returns a HistoryStore appending one json line per event to a file per execution in dir
+func NewFileHistoryStore(dir string) *fileHistoryStore {
+	return &fileHistoryStore{dir: dir}
+}
+
+type fileHistoryStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+func (f *fileHistoryStore) path(executionID string) string {
+	return filepath.Join(f.dir, url.PathEscape(executionID)+".jsonl")
+}
+
+func (f *fileHistoryStore) Append(_ context.Context, executionID string, events ...HistoryEvent) error {
+	var sb strings.Builder
+	for _, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		sb.Write(data)
+		sb.WriteByte('\n')
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	file, err := os.OpenFile(f.path(executionID), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(sb.String()); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *fileHistoryStore) Load(_ context.Context, executionID string) ([]HistoryEvent, error) {
+	file, err := os.Open(f.path(executionID))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	events := make([]HistoryEvent, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var e HistoryEvent
+		if err = json.Unmarshal(line, &e); err != nil {
+			// 崩溃时写了一半的最后一行
+			break
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
+
+// history records the events of an execution and replays the recorded ones
+type history struct {
+	mutex       sync.Mutex
+	store       HistoryStore
+	executionID string
+	recorded    map[string]HistoryEvent // 已记录的事件，用于重放
+	steps       map[string]string       // 运行或 TCC 的 id -> step
+	children    map[string]int          // step -> 已调度的子节点数
+	runs        map[string]int          // step:type -> 次数
+	err         error                   // 调度时发现的错误，由下一次运行返回
+}
+
+func newHistory(store HistoryStore, executionID string, events []HistoryEvent) *history {
+	h := &history{
+		store:       store,
+		executionID: executionID,
+		recorded:    make(map[string]HistoryEvent, len(events)),
+		steps:       make(map[string]string),
+		children:    make(map[string]int),
+		runs:        make(map[string]int),
+	}
+	for _, e := range events {
+		h.recorded[e.key()] = e
+	}
+	return h
+}
+
+func historyFrom(ctx context.Context) *history {
+	if e, ok := ctx.Value(executionKey{}).(*execution); ok {
+		return e.history
+	}
+	return nil
+}
+
+// append records an event unless it is already in the history, it returns the recorded event.
+// The run of the event is counted per step and type when run is 0.
+func (h *history) append(ctx context.Context, info Info, step string, typ HistoryEventType, run int,
+	err error, outputs map[string]json.RawMessage) (*HistoryEvent, error) {
+	h.mutex.Lock()
+	if run == 0 {
+		run = h.runs[step+":"+string(typ)] + 1
+		h.runs[step+":"+string(typ)] = run
+	}
+	e := HistoryEvent{
+		Step:   step,
+		Run:    run,
+		Type:   typ,
+		InfoID: info.ID(),
+		Name:   info.Name(),
+		Time:   time.Now(),
+	}
+	recorded, ok := h.recorded[e.key()]
+	h.mutex.Unlock()
+	if ok {
+		if recorded.Name != e.Name {
+			return nil, fmt.Errorf("%w: step %s was %q, now %q", ErrNonDeterministic, step, recorded.Name, e.Name)
+		}
+		return &recorded, nil
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	e.Outputs = outputs
+	if err = h.store.Append(detachedContext{ctx}, h.executionID, e); err != nil {
+		return nil, err
+	}
+	h.mutex.Lock()
+	h.recorded[e.key()] = e
+	h.mutex.Unlock()
+	return nil, nil
+}
+
+// step returns the step of info, the run of info executing in ctx when there is one
+func (h *history) step(ctx context.Context, info Info) (string, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if step, ok := h.steps[runOf(ctx, info).ID()]; ok {
+		return step, true
+	}
+	step, ok := h.steps[info.ID()]
+	return step, ok
+}
+
+type scheduledKey struct{}
+
+// scheduled is the step a task was scheduled as, its runs started with the ctx returned by link
+type scheduled struct {
+	definitionID string
+	step         string
+}
+
+// link schedules child as the next child step of the run of parent executing in ctx.
+// A TCC is bound to its step at once, a task when one of its runs starts with the returned ctx.
+func (h *history) link(ctx context.Context, parent, child Info) (context.Context, error) {
+	h.mutex.Lock()
+	parentStep, ok := h.steps[runOf(ctx, parent).ID()]
+	if !ok {
+		parentStep = rootStep
+	}
+	step := fmt.Sprintf("%s/%d", parentStep, h.children[parentStep])
+	h.children[parentStep]++
+	h.mutex.Unlock()
+	ctx = h.schedule(ctx, child, step)
+	_, err := h.append(ctx, child, step, HistoryScheduled, 0, nil, nil)
+	return ctx, err
+}
+
+func (h *history) schedule(ctx context.Context, info Info, step string) context.Context {
+	if _, ok := info.(Task); ok {
+		return context.WithValue(ctx, scheduledKey{}, &scheduled{definitionID: info.ID(), step: step})
+	}
+	h.mutex.Lock()
+	h.steps[info.ID()] = step
+	h.mutex.Unlock()
+	return ctx
+}
+
+// bind binds run, started as a child of parent with ctx, to the step its definition was
+// scheduled as, the run of a task wrapped by a run of the same definition (a retry) shares its step.
+// It returns the ctx of the run, which does not schedule its children any more.
+func (h *history) bind(ctx context.Context, parent, run Info) context.Context {
+	if s, ok := ctx.Value(scheduledKey{}).(*scheduled); ok && s.definitionID == run.DefinitionID() {
+		h.mutex.Lock()
+		h.steps[run.ID()] = s.step
+		h.mutex.Unlock()
+		return context.WithValue(ctx, scheduledKey{}, nil)
+	}
+	if parent != nil && parent.DefinitionID() == run.DefinitionID() {
+		h.mutex.Lock()
+		if step, ok := h.steps[parent.ID()]; ok {
+			h.steps[run.ID()] = step
+		}
+		h.mutex.Unlock()
+	}
+	return ctx
+}
+
+// root schedules info as the root step of the execution
+func (h *history) root(ctx context.Context, info Info) (context.Context, error) {
+	if h == nil {
+		return ctx, nil
+	}
+	ctx = h.schedule(ctx, info, rootStep)
+	_, err := h.append(ctx, info, rootStep, HistoryScheduled, 0, nil, nil)
+	return ctx, err
+}
+
+// finish records the outcome of the execution unless it was interrupted
+func (h *history) finish(ctx context.Context, info Info, err error) error {
+	if h == nil {
+		return nil
+	}
+	return (&historyRun{history: h, info: info, step: rootStep, run: 1}).end(ctx, err)
+}
+
+func (h *history) fail(err error) {
+	h.mutex.Lock()
+	if h.err == nil {
+		h.err = err
+	}
+	h.mutex.Unlock()
+}
+
+// recordHistory appends an event of info to the history of its execution
+func recordHistory(ctx context.Context, info Info, typ HistoryEventType, err error) error {
+	h := historyFrom(ctx)
+	if h == nil {
+		return nil
+	}
+	return h.record(ctx, info, typ, err)
+}
+
+// record appends an event of info, it is skipped when replayed
+func (h *history) record(ctx context.Context, info Info, typ HistoryEventType, err error) error {
+	step, ok := h.step(ctx, info)
+	if !ok {
+		return nil
+	}
+	_, err = h.append(ctx, info, step, typ, 0, err, nil)
+	return err
+}
+
+// historyRun is a run of a task with side effects
+type historyRun struct {
+	history *history
+	info    Info
+	step    string
+	run     int
+	outputs *outputs // 本次运行记录的输出
+}
+
+// beginRun records the start of a run of info, replayed reports that its outcome is
+// already in the history, which err then holds, and restores the outputs the run recorded.
+// The returned ctx collects the outputs of the run for its outcome.
+func beginRun(ctx context.Context, info Info) (_ context.Context, run *historyRun, replayed bool, err error) {
+	h := historyFrom(ctx)
+	if h == nil {
+		return ctx, nil, false, nil
+	}
+	step, ok := h.step(ctx, info)
+	if !ok {
+		return ctx, nil, false, nil
+	}
+	h.mutex.Lock()
+	err, h.err = h.err, nil
+	h.mutex.Unlock()
+	if err != nil {
+		return ctx, nil, false, err
+	}
+	started, err := h.append(ctx, info, step, HistoryStarted, 0, nil, nil)
+	if err != nil {
+		return ctx, nil, false, err
+	}
+	run = &historyRun{history: h, info: info, step: step}
+	h.mutex.Lock()
+	run.run = h.runs[step+":"+string(HistoryStarted)]
+	var outcome *HistoryEvent
+	for _, typ := range []HistoryEventType{HistoryCompleted, HistoryFailed} {
+		e := HistoryEvent{Step: step, Run: run.run, Type: typ}
+		if recorded, ok := h.recorded[e.key()]; ok {
+			outcome = &recorded
+		}
+	}
+	h.mutex.Unlock()
+	if started == nil || outcome == nil {
+		ctx, run.outputs = withOutputScope(ctx)
+		return ctx, run, false, nil
+	}
+	// 已完成的步骤不再执行，恢复其输出
+	for k, v := range outcome.Outputs {
+		SetOutput(ctx, k, v)
+	}
+	return ctx, nil, true, outcome.err()
+}
+
+// end records the outcome of the run with its outputs, which are then visible to the other steps
+func (r *historyRun) end(ctx context.Context, err error) error {
+	if r == nil {
+		return nil
+	}
+	var values map[string]interface{}
+	if r.outputs != nil {
+		values = r.outputs.own()
+		if r.outputs.parent != nil {
+			for k, v := range values {
+				r.outputs.parent.set(k, v)
+			}
+		}
+	}
+	// 被中断的运行不是结果，之后会重新执行
+	if ctx.Err() != nil || errors.Is(err, ErrSuspended) {
+		return nil
+	}
+	typ := HistoryCompleted
+	if err != nil {
+		typ = HistoryFailed
+	}
+	var outputs map[string]json.RawMessage
+	if len(values) > 0 {
+		outputs = make(map[string]json.RawMessage, len(values))
+		for k, v := range values {
+			data, marshalErr := json.Marshal(v)
+			if marshalErr != nil {
+				return fmt.Errorf("output %q: %w", k, marshalErr)
+			}
+			outputs[k] = data
+		}
+	}
+	_, appendErr := r.history.append(ctx, r.info, r.step, typ, r.run, err, outputs)
+	return appendErr
+}
+
+// Rebuild restores the info tree of an execution from its history, keyed by step.
+// The root is the step "0" when the execution ran in a registry.
+func Rebuild(events []HistoryEvent) map[string]Info {
+	var now time.Time
+	nowFunc := func() time.Time { return now }
+	infos := make(map[string]Info)
+	for _, e := range events {
+		now = e.Time
+		info, ok := infos[e.Step]
+		if !ok {
+			info = DefaultTaskInfo(e.InfoID, nowFunc)
+			info.SetName(e.Name)
+			if index := strings.LastIndex(e.Step, "/"); index > 0 {
+				if parent, ok := infos[e.Step[:index]]; ok {
+					info.SetParent(parent)
+				}
+			}
+			infos[e.Step] = info
+		}
+		rebuild(info, e)
+	}
+	return infos
+}
+
+// rebuild applies e to info the way the run or the phase it records updated its info
+func rebuild(info Info, e HistoryEvent) {
+	switch e.Type {
+	case HistoryScheduled:
+		info.SetState(Ready)
+	case HistoryStarted:
+		// 重试的运行绑定到同一个步骤
+		if info.State() == Error {
+			info.SetState(Retrying)
+		}
+		clearError(info)
+		info.SetState(Running)
+	case HistoryRetried:
+		info.SetState(Retrying)
+	case HistoryCompleted:
+		info.SetState(Success)
+	case HistoryFailed:
+		info.AddError(e.err())
+	case HistoryTried:
+		clearError(info)
+		info.SetState(Trying)
+		if err := e.err(); err != nil {
+			info.AddError(err, false)
+		}
+	case HistoryConfirmed:
+		info.SetState(Confirming)
+		info.AddError(e.err())
+	case HistoryCancelled:
+		from := info.State()
+		info.SetState(Cancelling)
+		endCancel(info, from, e.err())
+	}
+}
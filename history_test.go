@@ -0,0 +1,207 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func countingPipeline(counts []int, fail int) Task {
+	tasks := make([]Task, 0, len(counts))
+	for i := range counts {
+		i := i
+		tasks = append(tasks, NewFunc(func(context.Context, interface{}) error {
+			counts[i]++
+			if i == fail {
+				return errors.New("boom")
+			}
+			return nil
+		}))
+	}
+	return NewTaskPipeline().WithTasks(tasks...)
+}
+
+func TestHistoryReplay(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	registry := NewRegistry(WithHistoryStore(store))
+	counts := make([]int, 3)
+
+	require.NoError(t, registry.Execute(context.Background(), "order-1", countingPipeline(counts, -1), nil))
+	assert.Equal(t, []int{1, 1, 1}, counts)
+	events, err := store.Load(context.Background(), "order-1")
+	require.NoError(t, err)
+
+	// 重放已记录的执行不会再次产生副作用
+	require.NoError(t, registry.Execute(context.Background(), "order-1", countingPipeline(counts, -1), nil))
+	assert.Equal(t, []int{1, 1, 1}, counts)
+	replayed, err := store.Load(context.Background(), "order-1")
+	require.NoError(t, err)
+	assert.Equal(t, events, replayed)
+}
+
+func TestHistoryResumeAfterCrash(t *testing.T) {
+	store := NewFileHistoryStore(t.TempDir())
+	registry := NewRegistry(WithHistoryStore(store))
+	counts := make([]int, 3)
+
+	assert.Error(t, registry.Execute(context.Background(), "order-2", countingPipeline(counts, 1), nil))
+	assert.Equal(t, []int{1, 1, 0}, counts)
+
+	// 模拟崩溃：第二步的结果没有写入历史
+	events, err := store.Load(context.Background(), "order-2")
+	require.NoError(t, err)
+	crashed := NewMemoryHistoryStore()
+	for _, e := range events {
+		if e.Step == "0/1" && e.Type == HistoryFailed || e.Step == rootStep && e.Type == HistoryFailed {
+			continue
+		}
+		require.NoError(t, crashed.Append(context.Background(), "order-2", e))
+	}
+	registry = NewRegistry(WithHistoryStore(crashed))
+	require.NoError(t, registry.Execute(context.Background(), "order-2", countingPipeline(counts, -1), nil))
+	assert.Equal(t, []int{1, 2, 1}, counts)
+
+	// 失败的结果同样被重放
+	assert.EqualError(t,
+		NewRegistry(WithHistoryStore(store)).Execute(context.Background(), "order-2", countingPipeline(counts, -1), nil),
+		"boom")
+	assert.Equal(t, []int{1, 2, 1}, counts)
+}
+
+func TestHistoryNonDeterministic(t *testing.T) {
+	registry := NewRegistry(WithHistoryStore(NewMemoryHistoryStore()))
+	counts := make([]int, 2)
+	require.NoError(t, registry.Execute(context.Background(), "order-3", countingPipeline(counts, -1), nil))
+
+	charge := DefaultTaskInfo("")
+	charge.SetName("charge")
+	changed := NewTaskPipeline().WithTasks(
+		NewFunc(func(context.Context, interface{}) error { return nil }, WithInfo(charge)),
+		NewFunc(func(context.Context, interface{}) error { return nil }),
+	)
+	assert.ErrorIs(t, registry.Execute(context.Background(), "order-3", changed, nil), ErrNonDeterministic)
+}
+
+func TestRebuild(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	registry := NewRegistry(WithHistoryStore(store))
+	pipeline := countingPipeline(make([]int, 2), 1)
+	assert.Error(t, registry.Execute(context.Background(), "order-4", pipeline, nil))
+
+	events, err := store.Load(context.Background(), "order-4")
+	require.NoError(t, err)
+	infos := Rebuild(events)
+	require.Len(t, infos, 3)
+	assert.Equal(t, pipeline.ID(), infos[rootStep].ID())
+	assert.Equal(t, Error, infos[rootStep].State())
+	assert.Equal(t, Success, infos["0/0"].State())
+	assert.Equal(t, Error, infos["0/1"].State())
+	assert.Equal(t, pipeline.ID(), infos["0/1"].ParentID())
+	assert.Equal(t, "boom", infos["0/1"].Error().Error())
+}
+
+func TestHistoryReplayOutputs(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	registry := NewRegistry(WithHistoryStore(store))
+	var charged int
+	var receipt interface{}
+	newPipeline := func() Task {
+		return NewTaskPipeline().WithTasks(
+			NewFunc(func(ctx context.Context, _ interface{}) error {
+				charged++
+				SetOutput(ctx, "receipt", "r-1")
+				return nil
+			}),
+			NewFunc(func(ctx context.Context, _ interface{}) error {
+				receipt, _ = Output(ctx, "receipt")
+				return nil
+			}),
+		)
+	}
+	require.NoError(t, registry.Execute(context.Background(), "order-5", newPipeline(), nil))
+	assert.Equal(t, "r-1", receipt)
+
+	// 重放的步骤恢复其输出
+	ctx := WithOutputs(context.Background())
+	require.NoError(t, registry.Execute(ctx, "order-5", newPipeline(), nil))
+	assert.Equal(t, 1, charged)
+	v, _ := Output(ctx, "receipt")
+	assert.Equal(t, json.RawMessage(`"r-1"`), v)
+}
+
+func TestHistoryReusedDefinition(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	registry := NewRegistry(WithHistoryStore(store))
+	release := make(chan struct{})
+	step := NewFunc(func(_ context.Context, input interface{}) error {
+		if input == "host-0" {
+			// 第二项先开始运行
+			<-release
+			return nil
+		}
+		close(release)
+		return errors.New("boom")
+	})
+	task := NewMapTask(hosts(2)).WithConcurrency(2).WithTolerance(1).WithTask(func(interface{}) Task { return step })
+	require.NoError(t, registry.Execute(context.Background(), "order-6", task, nil))
+
+	events, err := store.Load(context.Background(), "order-6")
+	require.NoError(t, err)
+	outcomes := make(map[string]HistoryEventType)
+	for _, e := range events {
+		if e.Type == HistoryCompleted || e.Type == HistoryFailed {
+			outcomes[e.Step] = e.Type
+		}
+	}
+	// 同一定义的两次调度各有自己的步骤
+	assert.Equal(t, map[string]HistoryEventType{
+		rootStep: HistoryCompleted,
+		"0/0":    HistoryCompleted,
+		"0/1":    HistoryFailed,
+	}, outcomes)
+}
+
+func TestRebuildTCC(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	registry := NewRegistry(WithHistoryStore(store))
+	var calls int32
+	reserve := NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(UI))
+	charge := NewTCC(NewFunc(UI), failingTask(1, &calls), NewFunc(UI))
+	pipeline := NewTCCPipeline().WithTCCs(reserve, charge)
+	assert.Error(t, registry.ExecuteTCC(context.Background(), "order-5", pipeline, nil))
+
+	// 失败的 Confirm 与实际的状态一致
+	events, err := store.Load(context.Background(), "order-5")
+	require.NoError(t, err)
+	infos := Rebuild(events)
+	assert.Equal(t, Success, infos["0/0"].State())
+	assert.Equal(t, Error, charge.State())
+	assert.Equal(t, Error, infos["0/1"].State())
+	assert.Equal(t, charge.Error().Error(), infos["0/1"].Error().Error())
+	assert.Equal(t, []StateTransition{}, illegalTransitions(infos["0/1"]))
+
+	calls = 0
+	refund := NewTCC(NewFunc(UI), NewFunc(UI), failingTask(1, &calls))
+	declined := NewTCC(NewFunc(func(context.Context, interface{}) error { return errors.New("declined") }),
+		NewFunc(UI), NewFunc(UI))
+	pipeline = NewTCCPipeline().WithTCCs(refund, declined)
+	assert.Error(t, registry.ExecuteTCC(context.Background(), "order-6", pipeline, nil))
+
+	// 失败的 Cancel
+	events, err = store.Load(context.Background(), "order-6")
+	require.NoError(t, err)
+	infos = Rebuild(events)
+	assert.Equal(t, Error, refund.State())
+	assert.Equal(t, Error, infos["0/0"].State())
+	assert.Equal(t, refund.Error().Error(), infos["0/0"].Error().Error())
+	assert.Equal(t, declined.State(), infos["0/1"].State())
+	assert.Equal(t, Cancelled, infos["0/1"].State())
+	assert.Equal(t, "declined", infos["0/1"].Error().Error())
+	for _, info := range infos {
+		assert.Equal(t, []StateTransition{}, illegalTransitions(info))
+	}
+}
@@ -0,0 +1,244 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+const defaultRetention = 24 * time.Hour
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey attach an idempotency key to the execution started with ctx
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// IdempotencyKey returns the idempotency key attached to ctx
+func IdempotencyKey(ctx context.Context) string {
+	v, _ := ctx.Value(idempotencyKey{}).(string)
+	return v
+}
+
+// IdempotentResult is the outcome of a finished execution
+type IdempotentResult struct {
+	State State     `json:"state"`
+	Error string    `json:"error,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+func (r *IdempotentResult) Err() error {
+	if r.Error == "" {
+		return nil
+	}
+	return errors.New(r.Error)
+}
+
+// IdempotencyStore keeps the outcome of executions by idempotency key
+type IdempotencyStore interface {
+	Get(ctx context.Context, key string) (*IdempotentResult, bool, error)
+	Put(ctx context.Context, key string, result *IdempotentResult, retention time.Duration) error
+}
+
+// NewMemoryIdempotencyStore returns an IdempotencyStore kept in process memory
+func NewMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{
+		results: make(map[string]*expiringResult),
+	}
+}
+
+type expiringResult struct {
+	result   *IdempotentResult
+	deadline time.Time
+}
+
+type memoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	results map[string]*expiringResult
+}
+
+func (m *memoryIdempotencyStore) Get(_ context.Context, key string) (*IdempotentResult, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	v, ok := m.results[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if time.Now().After(v.deadline) {
+		delete(m.results, key)
+		return nil, false, nil
+	}
+	return v.result, true, nil
+}
+
+func (m *memoryIdempotencyStore) Put(_ context.Context, key string, result *IdempotentResult,
+	retention time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	// 顺带清理过期结果
+	for k, v := range m.results {
+		if now.After(v.deadline) {
+			delete(m.results, k)
+		}
+	}
+	m.results[key] = &expiringResult{result: result, deadline: now.Add(retention)}
+	return nil
+}
+
+type idempotencyOptions struct {
+	store     IdempotencyStore
+	retention time.Duration
+}
+
+type IdempotencyOption interface {
+	apply(*idempotencyOptions)
+}
+
+type storeIdempotencyOption struct {
+	store IdempotencyStore
+}
+
+func (s storeIdempotencyOption) apply(opts *idempotencyOptions) {
+	opts.store = s.store
+}
+
+func WithIdempotencyStore(store IdempotencyStore) IdempotencyOption {
+	return storeIdempotencyOption{store}
+}
+
+type retentionIdempotencyOption struct {
+	retention time.Duration
+}
+
+func (r retentionIdempotencyOption) apply(opts *idempotencyOptions) {
+	opts.retention = r.retention
+}
+
+// WithRetention set how long the result of an execution is kept for its idempotency key
+func WithRetention(retention time.Duration) IdempotencyOption {
+	return retentionIdempotencyOption{retention}
+}
+
+// IdempotentTask deduplicates the executions of t carrying the same idempotency key.
+// A second execution returns the result of the first one, or waits for it while it is running.
+// The results are stored per task, so the tasks sharing a store and a key run once each.
+func IdempotentTask(t Task, opts ...IdempotencyOption) Task {
+	opt := &idempotencyOptions{
+		retention: defaultRetention,
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.store == nil {
+		opt.store = NewMemoryIdempotencyStore()
+	}
+	return &idempotentTask{
+		Task:      t,
+		store:     opt.store,
+		retention: opt.retention,
+		calls:     make(map[string]*idempotentCall),
+	}
+}
+
+type idempotentTask struct {
+	Task
+	store     IdempotencyStore
+	retention time.Duration
+	mutex     sync.Mutex
+	calls     map[string]*idempotentCall // 正在执行的调用
+}
+
+func (i *idempotentTask) children() []Info {
+	return childrenOf(i.Task)
+}
+
+type idempotentCall struct {
+	done chan struct{}
+	run  Info
+	err  error
+}
+
+// isContextError reports whether err comes from a cancelled or timed out context
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func (i *idempotentTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	key := IdempotencyKey(ctx)
+	if key == "" {
+		return i.Task.Execute(ctx, input, callbacks...)
+	}
+	for {
+		i.mutex.Lock()
+		call, ok := i.calls[key]
+		if !ok {
+			call = &idempotentCall{done: make(chan struct{})}
+			i.calls[key] = call
+			i.mutex.Unlock()
+			return i.lead(ctx, key, call, input, callbacks)
+		}
+		i.mutex.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-call.done:
+		}
+		// 执行者的 ctx 被取消时，仍在等待的调用者重新选出执行者
+		if isContextError(call.err) && ctx.Err() == nil {
+			continue
+		}
+		for _, callback := range callbacks {
+			callback.Trigger(ctx, call.run, input, call.err)
+		}
+		return call.err
+	}
+}
+
+// lead runs t for the callers of key, unless the store already holds its result
+func (i *idempotentTask) lead(ctx context.Context, key string, call *idempotentCall, input interface{},
+	callbacks []Callback) error {
+	defer func() {
+		i.mutex.Lock()
+		delete(i.calls, key)
+		i.mutex.Unlock()
+		close(call.done)
+	}()
+	// 同一个存储中区分不同的任务
+	storeKey := fmt.Sprintf("%s/%s", key, i.ID())
+	result, ok, err := i.store.Get(ctx, storeKey)
+	if err != nil {
+		call.err = err
+		return err
+	}
+	if ok {
+		// 以保存的结果构造一次运行，供回调使用
+		call.run, call.err = i.NewRun(), result.Err()
+		call.run.SetState(result.State)
+		call.run.AddError(call.err, false)
+		for _, callback := range callbacks {
+			callback.Trigger(ctx, call.run, input, call.err)
+		}
+		return call.err
+	}
+
+	call.run, call.err = Run(ctx, i.Task, input, callbacks...)
+	if isContextError(call.err) {
+		// 被取消的执行不是结果，之后的调用会重新执行
+		return call.err
+	}
+	result = &IdempotentResult{
+		State: call.run.State(),
+		Time:  time.Now(),
+	}
+	if call.err != nil {
+		result.Error = call.err.Error()
+	}
+	err = i.store.Put(detachedContext{ctx}, storeKey, result, i.retention)
+	return multierr.Append(call.err, err)
+}
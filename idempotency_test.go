@@ -0,0 +1,146 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentTask(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	task := IdempotentTask(NewFunc(func(ctx context.Context, i interface{}) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return errors.New("failed")
+	}), WithRetention(time.Minute))
+
+	ctx := WithIdempotencyKey(context.Background(), "order-1")
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.EqualError(t, task.Execute(ctx, nil), "failed")
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.EqualError(t, task.Execute(ctx, nil), "failed")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	assert.EqualError(t, task.Execute(WithIdempotencyKey(context.Background(), "order-2"), nil), "failed")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestIdempotentTaskCallbacks(t *testing.T) {
+	release := make(chan struct{})
+	task := IdempotentTask(NewFunc(func(ctx context.Context, i interface{}) error {
+		<-release
+		return nil
+	}))
+	var triggered int32
+	callback := callbackFunc(func(_ context.Context, info Info, _ interface{}, err error) {
+		assert.NoError(t, err)
+		assert.Equal(t, Success, info.State())
+		atomic.AddInt32(&triggered, 1)
+	})
+
+	ctx := WithIdempotencyKey(context.Background(), "order-1")
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, task.Execute(ctx, nil, callback))
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	// 保存的结果也会触发回调
+	assert.NoError(t, task.Execute(ctx, nil, callback))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&triggered))
+}
+
+func TestIdempotentTaskCancelledLeader(t *testing.T) {
+	var calls int32
+	task := IdempotentTask(NewFunc(func(ctx context.Context, i interface{}) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}))
+
+	leaderCtx, cancel := context.WithCancel(WithIdempotencyKey(context.Background(), "order-1"))
+	done := make(chan error, 1)
+	go func() { done <- task.Execute(leaderCtx, nil) }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+
+	joined := make(chan error, 1)
+	go func() { joined <- task.Execute(WithIdempotencyKey(context.Background(), "order-1"), nil) }()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	// 等待者不继承执行者的取消，而是重新执行
+	assert.NoError(t, <-joined)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+// blockingStore blocks the lookups of the idempotency key "slow" until release is closed
+type blockingStore struct {
+	IdempotencyStore
+	release chan struct{}
+}
+
+func (b *blockingStore) Get(ctx context.Context, key string) (*IdempotentResult, bool, error) {
+	if strings.HasPrefix(key, "slow/") {
+		<-b.release
+	}
+	return b.IdempotencyStore.Get(ctx, key)
+}
+
+func TestIdempotentTaskStoreOutsideLock(t *testing.T) {
+	store := &blockingStore{IdempotencyStore: NewMemoryIdempotencyStore(), release: make(chan struct{})}
+	defer close(store.release)
+	task := IdempotentTask(NewFunc(UI), WithIdempotencyStore(store))
+	go func() { _ = task.Execute(WithIdempotencyKey(context.Background(), "slow"), nil) }()
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() { done <- task.Execute(WithIdempotencyKey(context.Background(), "fast"), nil) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("a slow lookup blocked the other keys")
+	}
+}
+
+func TestIdempotentTaskSharedStore(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	var a, b int32
+	pipeline := NewTaskPipeline().WithTasks(
+		IdempotentTask(NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(&a, 1)
+			return nil
+		}), WithIdempotencyStore(store)),
+		IdempotentTask(NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(&b, 1)
+			return nil
+		}), WithIdempotencyStore(store)),
+	)
+	ctx := WithIdempotencyKey(context.Background(), "order-3")
+	assert.NoError(t, pipeline.Execute(ctx, nil))
+	assert.NoError(t, pipeline.Execute(ctx, nil))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&a))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&b))
+}
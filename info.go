@@ -1,6 +1,7 @@
 package workflow
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,10 +17,38 @@ const (
 	Running State = "running"
 	Success State = "success"
 	Error   State = "error"
+	// Cancelled is set on the infos of an execution stopped by its registry
+	Cancelled State = "cancelled"
+	// Paused is set on the pipelines waiting at a step boundary of a paused execution
+	Paused State = "paused"
+	// Pending and Scheduled precede Ready for the infos waiting to be picked up, such as the tasks of
+	// the timers of a time wheel
+	Pending   State = "pending"
+	Scheduled State = "scheduled"
+	// Retrying is set on a retry task between two attempts
+	Retrying State = "retrying"
+	// Trying, Confirming and Cancelling are set on a TCC during its phases
+	Trying     State = "trying"
+	Confirming State = "confirming"
+	Cancelling State = "cancelling"
+	// Skipped is set on a choice task taking no branch
+	Skipped State = "skipped"
+	// TimedOut is set on a TCC group whose phase failed after its timeout
+	TimedOut State = "timed_out"
+	// Compensated is set once the effects of a successful info were undone, such as a confirmed TCC cancelled
+	Compensated State = "compensated"
 )
 
 type Info interface {
 	ID() string
+	// DefinitionID is the id of the task the info is a run of, empty for a definition
+	DefinitionID() string
+	// ParentID is the id of the composite that runs the info, empty for a root
+	ParentID() string
+	// RootID is the id of the outermost composite, the info's own id for a root
+	RootID() string
+	// ExecutionID is the id of the registry execution the info last ran in
+	ExecutionID() string
 	Name() string
 	Trigger() string
 	State() State
@@ -27,14 +56,28 @@ type Info interface {
 	CreateTime() time.Time
 	UpdateTime() time.Time
 	Metadata() []byte
+	// Labels returns a copy of the labels, the inherited ones included
+	Labels() map[string]string
 	Error() error
 
+	// SetParent sets the parent, the labels inherited from a previous parent are replaced by its labels
+	SetParent(Info)
+	SetExecutionID(string)
 	SetTrigger(string)
 	SetName(string)
+	// SetState moves to the state without validation, an illegal move triggers the OnIllegal hooks of
+	// DefaultStateMachine
 	SetState(State)
+	// Transition moves to the state when DefaultStateMachine allows it, the state is unchanged otherwise
+	Transition(State) error
+	// Transitions returns the latest state transitions, oldest first
+	Transitions() []StateTransition
 	SetDescription(string)
 	SetMetadata([]byte)
+	SetLabel(key, value string)
 	AddError(err error, states ...bool)
+	// NewRun returns the info of a new run of the definition
+	NewRun() Info
 }
 
 func DefaultTaskInfo(id string, f ...func() time.Time) Info {
@@ -64,23 +107,52 @@ func DefaultTaskInfo(id string, f ...func() time.Time) Info {
 }
 
 type defaultTaskInfo struct {
-	id          string
-	nowFunc     func() time.Time
-	name        atomic.Value
-	trigger     atomic.Value
-	state       atomic.Value
-	description atomic.Value
-	meta        atomic.Value
-	createTime  time.Time
-	updateTime  atomic.Value
-	mutex       sync.RWMutex
-	err         error
+	id           string
+	definitionID string
+	nowFunc      func() time.Time
+	parentID     atomic.Value
+	rootID       atomic.Value
+	executionID  atomic.Value
+	name         atomic.Value
+	trigger      atomic.Value
+	state        atomic.Value
+	description  atomic.Value
+	meta         atomic.Value
+	createTime   time.Time
+	updateTime   atomic.Value
+	mutex        sync.RWMutex
+	err          error
+	transitions  []StateTransition
+	labels       map[string]string
+	inherited    map[string]string // 父节点的标签，更换父节点时替换
+	started      []Info            // 运行启动的子运行及关联的 TCC
 }
 
 func (t *defaultTaskInfo) ID() string {
 	return t.id
 }
 
+func (t *defaultTaskInfo) DefinitionID() string {
+	return t.definitionID
+}
+
+func (t *defaultTaskInfo) ParentID() string {
+	v, _ := t.parentID.Load().(string)
+	return v
+}
+
+func (t *defaultTaskInfo) RootID() string {
+	if v, _ := t.rootID.Load().(string); v != "" {
+		return v
+	}
+	return t.id
+}
+
+func (t *defaultTaskInfo) ExecutionID() string {
+	v, _ := t.executionID.Load().(string)
+	return v
+}
+
 func (t *defaultTaskInfo) Name() string {
 	v, _ := t.name.Load().(string)
 	return v
@@ -115,12 +187,69 @@ func (t *defaultTaskInfo) Metadata() []byte {
 	return v
 }
 
+func (t *defaultTaskInfo) Labels() map[string]string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	labels := make(map[string]string, len(t.inherited)+len(t.labels))
+	for k, v := range t.inherited {
+		labels[k] = v
+	}
+	for k, v := range t.labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+func (t *defaultTaskInfo) SetLabel(key, value string) {
+	t.mutex.Lock()
+	if t.labels == nil {
+		t.labels = make(map[string]string)
+	}
+	t.labels[key] = value
+	t.mutex.Unlock()
+	t.updateTime.Store(t.nowFunc())
+}
+
 func (t *defaultTaskInfo) Error() error {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 	return t.err
 }
 
+func (t *defaultTaskInfo) SetParent(parent Info) {
+	t.parentID.Store(parent.ID())
+	t.rootID.Store(parent.RootID())
+	// 继承父节点的标签，自身的标签优先
+	inherited := parent.Labels()
+	t.mutex.Lock()
+	t.inherited = inherited
+	t.mutex.Unlock()
+	t.updateTime.Store(t.nowFunc())
+}
+
+func (t *defaultTaskInfo) addChild(child Info) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, c := range t.started {
+		// 循环中同一个 TCC 会被多次关联
+		if c.ID() == child.ID() {
+			return
+		}
+	}
+	t.started = append(t.started, child)
+}
+
+func (t *defaultTaskInfo) children() []Info {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]Info(nil), t.started...)
+}
+
+func (t *defaultTaskInfo) SetExecutionID(id string) {
+	t.executionID.Store(id)
+	t.updateTime.Store(t.nowFunc())
+}
+
 func (t *defaultTaskInfo) SetTrigger(s string) {
 	t.trigger.Store(s)
 	t.updateTime.Store(t.nowFunc())
@@ -137,8 +266,42 @@ func (t *defaultTaskInfo) SetName(name string) {
 }
 
 func (t *defaultTaskInfo) SetState(state State) {
+	t.mutex.Lock()
+	from := t.State()
+	t.setState(state)
+	t.mutex.Unlock()
+	if !DefaultStateMachine.Can(from, state) {
+		DefaultStateMachine.illegal(t, from, state)
+	}
+}
+
+func (t *defaultTaskInfo) Transition(state State) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if err := DefaultStateMachine.Validate(t.State(), state); err != nil {
+		return fmt.Errorf("%s: %w", t.id, err)
+	}
+	t.setState(state)
+	return nil
+}
+
+func (t *defaultTaskInfo) Transitions() []StateTransition {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return append([]StateTransition(nil), t.transitions...)
+}
+
+// setState stores the state and records the transition, the caller holds the mutex
+func (t *defaultTaskInfo) setState(state State) {
+	now := t.nowFunc()
+	if from := t.State(); from != state {
+		t.transitions = append(t.transitions, StateTransition{From: from, To: state, Time: now})
+		if len(t.transitions) > maxTransitions {
+			t.transitions = t.transitions[len(t.transitions)-maxTransitions:]
+		}
+	}
 	t.state.Store(state)
-	t.updateTime.Store(t.nowFunc())
+	t.updateTime.Store(now)
 }
 
 func (t *defaultTaskInfo) SetDescription(desc string) {
@@ -155,6 +318,13 @@ func (t *defaultTaskInfo) setError(err error) {
 	}
 }
 
+func (t *defaultTaskInfo) clearError() {
+	t.mutex.Lock()
+	t.err = nil
+	t.mutex.Unlock()
+	t.updateTime.Store(t.nowFunc())
+}
+
 func (t *defaultTaskInfo) AddError(err error, states ...bool) {
 	setState := true
 	if len(states) > 0 {
@@ -183,3 +353,19 @@ func (t *defaultTaskInfo) AddError(err error, states ...bool) {
 		t.setError(err)
 	}
 }
+
+func (t *defaultTaskInfo) NewRun() Info {
+	run := DefaultTaskInfo("", t.nowFunc).(*defaultTaskInfo)
+	run.definitionID = t.id
+	run.SetName(t.Name())
+	run.SetTrigger(t.Trigger())
+	run.SetDescription(t.Description())
+	run.SetMetadata(t.Metadata())
+	t.mutex.RLock()
+	for k, v := range t.labels {
+		run.SetLabel(k, v)
+	}
+	run.inherited = t.inherited
+	t.mutex.RUnlock()
+	return run
+}
@@ -0,0 +1,334 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	uuid "github.com/satori/go.uuid"
+)
+
+var ErrInterventionNotFound = errors.New("intervention not found")
+
+// ErrInterventionRetrying is returned by a Retry of a parked phase whose previous Retry is still running
+var ErrInterventionRetrying = errors.New("intervention is being retried")
+
+// phaseRetry runs the Confirm and Cancel phases of a TCC until they succeed,
+// the phases still failing are parked in its queue
+type phaseRetry struct {
+	attempts int
+	interval time.Duration
+	clock    Clock
+	queue    *InterventionQueue
+}
+
+func newPhaseRetry(opt *options) *phaseRetry {
+	if opt.phaseRetry == nil && opt.interventions == nil {
+		return nil
+	}
+	retryOpt := &retryOptions{
+		attempts: 1,
+		interval: defaultInterval,
+		clock:    SystemClock,
+	}
+	if opt.phaseRetry != nil {
+		retryOpt.attempts = defaultAttempt
+	}
+	for _, o := range opt.phaseRetry {
+		o.apply(retryOpt)
+	}
+	return &phaseRetry{
+		attempts: retryOpt.attempts,
+		interval: retryOpt.interval,
+		clock:    retryOpt.clock,
+		queue:    opt.interventions,
+	}
+}
+
+func (p *phaseRetry) newBackOff() backoff.BackOff {
+	if p.attempts < 2 || p.interval <= 0 {
+		return &backoff.ZeroBackOff{}
+	}
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = p.interval
+	b.Multiplier = math.Pow(2, 1/float64(p.attempts-1))
+	b.MaxElapsedTime = 0
+	b.Clock = p.clock
+	b.Reset()
+	return b
+}
+
+type retryScopeKey struct{}
+
+// retryScope tells a phaseRetry whether a phaseRetry nested in its call already retried the phase
+type retryScope struct {
+	handled int32
+}
+
+type interventionKey struct{}
+
+// intervened reports whether ctx runs a phase retried through an InterventionQueue
+func intervened(ctx context.Context) bool {
+	v, _ := ctx.Value(interventionKey{}).(bool)
+	return v
+}
+
+type errorClearer interface {
+	clearError()
+}
+
+// clearError drops the errors of info, when it keeps them itself
+func clearError(info Info) {
+	if c, ok := info.(errorClearer); ok {
+		c.clearError()
+	}
+}
+
+// run calls the phase of tcc, it runs once without a phaseRetry. Only the innermost phaseRetry
+// retries a phase and parks it, an outer one returns the error of a call retried inside.
+func (p *phaseRetry) run(ctx context.Context, tcc TCC, phase Phase, input interface{},
+	call func(ctx context.Context) error) error {
+	if p == nil || intervened(ctx) {
+		return call(ctx)
+	}
+	if scope, ok := ctx.Value(retryScopeKey{}).(*retryScope); ok {
+		atomic.StoreInt32(&scope.handled, 1)
+	}
+	backOff := p.newBackOff()
+	var (
+		attempts int
+		err      error
+	)
+	for {
+		attempts++
+		scope := &retryScope{}
+		if err = call(context.WithValue(ctx, retryScopeKey{}, scope)); err == nil {
+			return nil
+		}
+		if atomic.LoadInt32(&scope.handled) == 1 {
+			// 内层已重试并转人工处理
+			return err
+		}
+		if attempts >= p.attempts {
+			break
+		}
+		timer := p.clock.NewTimer(backOff.NextBackOff())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+		case <-timer.C():
+			continue
+		}
+		break
+	}
+	if p.queue != nil {
+		p.queue.park(ctx, tcc, phase, input, attempts, err)
+	}
+	return err
+}
+
+// Intervention is a Confirm or Cancel that kept failing and needs an operator
+type Intervention struct {
+	ID          string      `json:"id"`
+	ExecutionID string      `json:"execution_id,omitempty"`
+	TCCID       string      `json:"tcc_id"`
+	TCCName     string      `json:"tcc_name"`
+	Phase       Phase       `json:"phase"`
+	Input       interface{} `json:"input"`
+	Error       string      `json:"error"`
+	Attempts    int         `json:"attempts"`
+	Retrying    bool        `json:"retrying"`
+	CreateTime  time.Time   `json:"create_time"`
+	UpdateTime  time.Time   `json:"update_time"`
+	tcc         TCC
+	execution   *execution
+	gid         string
+	parents     *phaseFrame // 包含该 TCC 的 TCC
+}
+
+// NewInterventionQueue returns an empty queue of failed phases
+func NewInterventionQueue() *InterventionQueue {
+	return &InterventionQueue{
+		items: make(map[string]*Intervention),
+	}
+}
+
+// InterventionQueue keeps the Confirm and Cancel phases that failed after their retries,
+// they can be listed, retried and resolved
+type InterventionQueue struct {
+	mutex sync.Mutex
+	items map[string]*Intervention
+	order []string
+}
+
+func (q *InterventionQueue) park(ctx context.Context, tcc TCC, phase Phase, input interface{}, attempts int,
+	err error) {
+	now := time.Now()
+	parents, _ := ctx.Value(phaseKey{}).(*phaseFrame)
+	for parents != nil && parents.tcc.ID() == tcc.ID() {
+		parents = parents.parent
+	}
+	e, _ := ctx.Value(executionKey{}).(*execution)
+	item := &Intervention{
+		ID:          uuid.NewV4().String(),
+		ExecutionID: ExecutionID(ctx),
+		TCCID:       tcc.ID(),
+		TCCName:     tcc.Name(),
+		Phase:       phase,
+		Input:       input,
+		Error:       err.Error(),
+		Attempts:    attempts,
+		CreateTime:  now,
+		UpdateTime:  now,
+		tcc:         tcc,
+		execution:   e,
+		gid:         TransactionID(ctx),
+		parents:     parents,
+	}
+	q.mutex.Lock()
+	q.items[item.ID] = item
+	q.order = append(q.order, item.ID)
+	q.mutex.Unlock()
+}
+
+// List returns the parked phases, oldest first
+func (q *InterventionQueue) List() []Intervention {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	list := make([]Intervention, 0, len(q.order))
+	for _, id := range q.order {
+		list = append(list, *q.items[id])
+	}
+	return list
+}
+
+// Retry runs the parked phase id once more through its TCC, in the execution and transaction
+// it failed in. It leaves the queue when it succeeds, and the TCCs containing it whose other
+// branches succeeded too end the phase as well. A phase is retried once at a time,
+// ErrInterventionRetrying is returned while it runs.
+func (q *InterventionQueue) Retry(ctx context.Context, id string) error {
+	q.mutex.Lock()
+	item, ok := q.items[id]
+	if !ok {
+		q.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrInterventionNotFound, id)
+	}
+	if item.Retrying {
+		q.mutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrInterventionRetrying, id)
+	}
+	item.Retrying = true
+	q.mutex.Unlock()
+	ctx = context.WithValue(ctx, interventionKey{}, true)
+	if item.execution != nil {
+		ctx = context.WithValue(ctx, executionKey{}, item.execution)
+	}
+	if item.gid != "" {
+		ctx = WithTransactionID(ctx, item.gid)
+	}
+	var err error
+	if item.Phase == PhaseConfirm {
+		err = item.tcc.Confirm(ctx, item.Input)
+	} else {
+		err = item.tcc.Cancel(ctx, item.Input)
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	item.Retrying = false
+	if err != nil {
+		item.Attempts++
+		item.Error = err.Error()
+		item.UpdateTime = time.Now()
+		return err
+	}
+	q.remove(id)
+	settle(item.parents, item.Phase)
+	return nil
+}
+
+// settle ends the phase of the TCCs of frames once none of their branches failed it any more
+func settle(frames *phaseFrame, phase Phase) {
+	// 失败的阶段经重试完成
+	moves, done := []State{Confirming, Success}, map[State]bool{Success: true}
+	if phase == PhaseCancel {
+		// 未尝试的分支不需要 Cancel
+		moves, done = []State{Cancelling, Cancelled}, map[State]bool{Cancelled: true, Compensated: true, Ready: true}
+	}
+	for f := frames; f != nil; f = f.parent {
+		for _, child := range childrenOf(f.tcc) {
+			if !done[child.State()] {
+				return
+			}
+		}
+		clearError(f.info)
+		for _, state := range moves {
+			f.info.SetState(state)
+		}
+	}
+}
+
+// Resolve removes the phase id, after an operator fixed it by other means
+func (q *InterventionQueue) Resolve(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, ok := q.items[id]; !ok {
+		return fmt.Errorf("%w: %s", ErrInterventionNotFound, id)
+	}
+	q.remove(id)
+	return nil
+}
+
+func (q *InterventionQueue) remove(id string) {
+	delete(q.items, id)
+	for i, v := range q.order {
+		if v == id {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// InterventionHandler serves the queue: GET lists the parked phases,
+// POST {id}/retry retries one and DELETE {id} resolves one
+func InterventionHandler(queue *InterventionQueue) http.Handler {
+	return &interventionHandler{queue: queue}
+}
+
+type interventionHandler struct {
+	queue *InterventionQueue
+}
+
+func (h *interventionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && parts[0] == "":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(h.queue.List())
+	case r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "retry":
+		h.reply(w, h.queue.Retry(r.Context(), parts[0]))
+	case r.Method == http.MethodDelete && len(parts) == 1 && parts[0] != "":
+		h.reply(w, h.queue.Resolve(parts[0]))
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+func (h *interventionHandler) reply(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrInterventionNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusConflict)
+	}
+}
@@ -0,0 +1,134 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingTask fails its first failures runs
+func failingTask(failures int32, calls *int32) Task {
+	return NewFunc(func(context.Context, interface{}) error {
+		if atomic.AddInt32(calls, 1) <= failures {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+}
+
+func TestPhaseRetry(t *testing.T) {
+	var confirms, cancels int32
+	tcc := NewTCC(NewFunc(UI), failingTask(2, &confirms), NewFunc(UI),
+		WithPhaseRetry(WithAttempt(3), WithInterval(time.Millisecond)))
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	assert.NoError(t, tcc.Confirm(context.Background(), nil))
+	assert.Equal(t, int32(3), confirms)
+
+	// 分组重试分支的 Cancel
+	group := NewTCCGroup(WithPhaseRetry(WithAttempt(2), WithInterval(time.Millisecond))).WithTCCs(
+		NewTCC(NewFunc(UI), NewFunc(UI), failingTask(1, &cancels)))
+	require.NoError(t, group.Try(context.Background(), nil))
+	assert.NoError(t, group.Cancel(context.Background(), nil))
+	assert.Equal(t, int32(2), cancels)
+}
+
+func TestInterventionQueue(t *testing.T) {
+	queue := NewInterventionQueue()
+	var cancels int32
+	tcc := NewTCC(NewFunc(UI), NewFunc(UI), failingTask(3, &cancels),
+		WithPhaseRetry(WithAttempt(2), WithInterval(time.Millisecond)), WithInterventionQueue(queue))
+	require.NoError(t, tcc.Try(context.Background(), "order-1"))
+	assert.EqualError(t, tcc.Cancel(context.Background(), "order-1"), "unavailable")
+	assert.Equal(t, Error, tcc.State())
+
+	server := httptest.NewServer(InterventionHandler(queue))
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	var list []Intervention
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	resp.Body.Close()
+	require.Len(t, list, 1)
+	assert.Equal(t, tcc.ID(), list[0].TCCID)
+	assert.Equal(t, PhaseCancel, list[0].Phase)
+	assert.Equal(t, "order-1", list[0].Input)
+	assert.Equal(t, 2, list[0].Attempts)
+
+	retry := func(id string) int {
+		resp, err := http.Post(server.URL+"/"+id+"/retry", "", nil)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusConflict, retry(list[0].ID))
+	assert.Equal(t, 3, queue.List()[0].Attempts)
+	assert.Equal(t, http.StatusNoContent, retry(list[0].ID))
+	assert.Empty(t, queue.List())
+	// 重试经过 TCC 本身，结束 Cancel 阶段
+	assert.Equal(t, Cancelled, tcc.State())
+	assert.NoError(t, tcc.Error())
+	assert.Equal(t, http.StatusNotFound, retry(list[0].ID))
+}
+
+func TestInterventionNestedRetry(t *testing.T) {
+	queue := NewInterventionQueue()
+	var confirms int32
+	branch := NewTCC(NewFunc(UI), failingTask(10, &confirms), NewFunc(UI),
+		WithPhaseRetry(WithAttempt(2), WithInterval(time.Millisecond)), WithInterventionQueue(queue))
+	group := NewTCCGroup(WithPhaseRetry(WithAttempt(3), WithInterval(time.Millisecond)),
+		WithInterventionQueue(queue)).WithTCCs(branch, NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(UI)))
+	require.NoError(t, group.Try(context.Background(), nil))
+	assert.Error(t, group.Confirm(context.Background(), nil))
+	// 只有分支自身重试，也只转人工一次
+	assert.Equal(t, int32(2), atomic.LoadInt32(&confirms))
+	list := queue.List()
+	require.Len(t, list, 1)
+	assert.Equal(t, branch.ID(), list[0].TCCID)
+	assert.Equal(t, Error, group.State())
+
+	atomic.StoreInt32(&confirms, 10)
+	require.NoError(t, queue.Retry(context.Background(), list[0].ID))
+	assert.Equal(t, Success, branch.State())
+	// 其余分支已成功，分组同样结束
+	assert.Equal(t, Success, group.State())
+	assert.NoError(t, group.Error())
+}
+
+func TestInterventionRetryInFlight(t *testing.T) {
+	queue := NewInterventionQueue()
+	var cancels int32
+	started, release := make(chan struct{}), make(chan struct{})
+	cancel := NewFunc(func(context.Context, interface{}) error {
+		if atomic.AddInt32(&cancels, 1) <= 2 {
+			return errors.New("unavailable")
+		}
+		close(started)
+		<-release
+		return nil
+	})
+	tcc := NewTCC(NewFunc(UI), NewFunc(UI), cancel,
+		WithPhaseRetry(WithAttempt(2), WithInterval(time.Millisecond)), WithInterventionQueue(queue))
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	require.Error(t, tcc.Cancel(context.Background(), nil))
+	list := queue.List()
+	require.Len(t, list, 1)
+
+	done := make(chan error)
+	go func() { done <- queue.Retry(context.Background(), list[0].ID) }()
+	<-started
+	// 重试进行中时再次重试被拒绝
+	assert.ErrorIs(t, queue.Retry(context.Background(), list[0].ID), ErrInterventionRetrying)
+	assert.True(t, queue.List()[0].Retrying)
+	close(release)
+	require.NoError(t, <-done)
+	assert.Empty(t, queue.List())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&cancels))
+}
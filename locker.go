@@ -0,0 +1,156 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Locker grants the lease of a key to one owner at a time
+type Locker interface {
+	// Acquire takes the lease of key for owner or renews it when owner already holds it,
+	// it reports whether owner holds the lease for the next ttl
+	Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+	// Release gives up the lease if owner holds it
+	Release(ctx context.Context, key, owner string) error
+}
+
+// Leader is consulted by schedulers before firing, only the leader fires
+type Leader interface {
+	IsLeader() bool
+}
+
+// pruneInterval is how often the lockers remove the expired leases, such as the claims of fired timers
+// which are never acquired again
+const pruneInterval = time.Minute
+
+// pruner tells when the expired leases of a locker are due for removal
+type pruner struct {
+	mutex sync.Mutex
+	last  time.Time
+}
+
+// due reports whether the expired leases should be removed at now
+func (p *pruner) due(now time.Time) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if now.Sub(p.last) < pruneInterval {
+		return false
+	}
+	p.last = now
+	return true
+}
+
+// NewMemoryLocker returns a Locker shared by the schedulers of one process
+func NewMemoryLocker() *memoryLocker {
+	return &memoryLocker{
+		leases: make(map[string]lease),
+	}
+}
+
+type lease struct {
+	owner    string
+	deadline time.Time
+}
+
+type memoryLocker struct {
+	mutex  sync.Mutex
+	leases map[string]lease
+	pruner pruner
+}
+
+func (m *memoryLocker) Acquire(_ context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	now := time.Now()
+	if m.pruner.due(now) {
+		for k, l := range m.leases {
+			if !now.Before(l.deadline) {
+				delete(m.leases, k)
+			}
+		}
+	}
+	if l, ok := m.leases[key]; ok && l.owner != owner && now.Before(l.deadline) {
+		return false, nil
+	}
+	m.leases[key] = lease{owner: owner, deadline: now.Add(ttl)}
+	return true, nil
+}
+
+func (m *memoryLocker) Release(_ context.Context, key, owner string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if l, ok := m.leases[key]; ok && l.owner == owner {
+		delete(m.leases, key)
+	}
+	return nil
+}
+
+// NewElector returns an elector campaigning for the lease of key as owner.
+// The lease is renewed every ttl/3, so a dead leader is replaced within ttl
+// and a stopped leader is replaced within ttl/3.
+func NewElector(locker Locker, key, owner string, ttl time.Duration) *elector {
+	return &elector{
+		locker: locker,
+		key:    key,
+		owner:  owner,
+		ttl:    ttl,
+	}
+}
+
+type elector struct {
+	locker   Locker
+	key      string
+	owner    string
+	ttl      time.Duration
+	deadline atomic.Value // 租约到期时间，续约失败后到期即失去领导权
+}
+
+func (e *elector) IsLeader() bool {
+	deadline, _ := e.deadline.Load().(time.Time)
+	return time.Now().Before(deadline)
+}
+
+// Run campaigns until ctx is done, then releases the lease
+func (e *elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		e.campaign(ctx)
+		select {
+		case <-ctx.Done():
+			e.deadline.Store(time.Time{})
+			releaseCtx, cancel := context.WithTimeout(context.Background(), e.ttl)
+			_ = e.locker.Release(releaseCtx, e.key, e.owner)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// failover is the time a dead leader takes to be replaced, with some margin
+func (e *elector) failover() time.Duration {
+	return 2 * e.ttl
+}
+
+// claim takes the firing of the timer id in the locker, for longer than the other replicas keep it.
+// The claim is not released, the locker removes it once expired.
+func (e *elector) claim(ctx context.Context, id string) (bool, error) {
+	return e.locker.Acquire(ctx, e.key+"/timer/"+id, e.owner, 2*e.failover())
+}
+
+func (e *elector) campaign(ctx context.Context) {
+	start := time.Now()
+	ok, err := e.locker.Acquire(ctx, e.key, e.owner, e.ttl)
+	if err != nil {
+		return
+	}
+	if !ok {
+		e.deadline.Store(time.Time{})
+		return
+	}
+	// 以请求发起时间计算，避免高估租约
+	e.deadline.Store(start.Add(e.ttl))
+}
@@ -0,0 +1,178 @@
+package workflow
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testLocker(t *testing.T, l Locker) {
+	ctx := context.Background()
+	ok, err := l.Acquire(ctx, "cron", "a", 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = l.Acquire(ctx, "cron", "b", time.Second)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// 续约
+	ok, err = l.Acquire(ctx, "cron", "a", 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// 租约过期后可被抢占
+	time.Sleep(150 * time.Millisecond)
+	ok, err = l.Acquire(ctx, "cron", "b", time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, l.Release(ctx, "cron", "a"))
+	ok, err = l.Acquire(ctx, "cron", "a", time.Second)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, l.Release(ctx, "cron", "b"))
+	ok, err = l.Acquire(ctx, "cron", "a", time.Second)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestMemoryLocker(t *testing.T) {
+	testLocker(t, NewMemoryLocker())
+}
+
+func TestFileLocker(t *testing.T) {
+	testLocker(t, NewFileLocker(t.TempDir()))
+}
+
+func TestLockerPrune(t *testing.T) {
+	ctx := context.Background()
+	memory, dir := NewMemoryLocker(), t.TempDir()
+	file := NewFileLocker(dir)
+	for _, l := range []Locker{memory, file} {
+		ok, err := l.Acquire(ctx, "timer/timer/1", "a", 10*time.Millisecond)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	time.Sleep(20 * time.Millisecond)
+	// 下一次抢占时清理过期的租约
+	memory.pruner.last, file.pruner.last = time.Time{}, time.Time{}
+	for _, l := range []Locker{memory, file} {
+		ok, err := l.Acquire(ctx, "timer", "a", time.Second)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	assert.Len(t, memory.leases, 1)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.ElementsMatch(t, []string{"timer.lease", "leases.mutex"}, names)
+}
+
+func TestElectorFailover(t *testing.T) {
+	locker := NewMemoryLocker()
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	e1 := NewElector(locker, "timer", "a", 90*time.Millisecond)
+	go e1.Run(ctx1)
+	assert.Eventually(t, e1.IsLeader, time.Second, 5*time.Millisecond)
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	e2 := NewElector(locker, "timer", "b", 90*time.Millisecond)
+	go e2.Run(ctx2)
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, e2.IsLeader())
+
+	cancel1()
+	assert.Eventually(t, e2.IsLeader, time.Second, 5*time.Millisecond)
+	assert.False(t, e1.IsLeader())
+}
+
+type callbackFunc func(ctx context.Context, info Info, input interface{}, err error)
+
+func (f callbackFunc) Trigger(ctx context.Context, info Info, input interface{}, err error) {
+	f(ctx, info, input, err)
+}
+
+func TestTimeWheelLeader(t *testing.T) {
+	var fired int32
+	newWheel := func(leader Leader) *timeWheel {
+		tw := NewTimeWheel(10*time.Millisecond, 10, WithLeader(leader))
+		tw.callback = []Callback{callbackFunc(func(context.Context, Info, interface{}, error) {
+			atomic.AddInt32(&fired, 1)
+		})}
+		return tw
+	}
+	locker := NewMemoryLocker()
+	ok, err := locker.Acquire(context.Background(), "timer", "a", time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	leader := NewElector(locker, "timer", "a", time.Minute)
+	leader.campaign(context.Background())
+	follower := NewElector(locker, "timer", "b", time.Minute)
+	follower.campaign(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	task := NewFunc(UI)
+	for _, tw := range []*timeWheel{newWheel(leader), newWheel(follower)} {
+		tw.Start(ctx)
+		tw.AddTimer(DelayTask{Delay: 20 * time.Millisecond, Task: task})
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fired))
+}
+
+func TestTimeWheelFailover(t *testing.T) {
+	fired := make(map[string]int)
+	var mutex sync.Mutex
+	newWheel := func(leader Leader) *timeWheel {
+		return NewTimeWheel(10*time.Millisecond, 10, WithLeader(leader), WithTimerCallbacks(
+			callbackFunc(func(_ context.Context, info Info, _ interface{}, _ error) {
+				mutex.Lock()
+				fired[info.ID()]++
+				mutex.Unlock()
+			})))
+	}
+	locker := NewMemoryLocker()
+	leader := NewElector(locker, "timer", "a", 100*time.Millisecond)
+	leader.campaign(context.Background())
+	follower := NewElector(locker, "timer", "b", 100*time.Millisecond)
+	follower.campaign(context.Background())
+	require.True(t, leader.IsLeader())
+	require.False(t, follower.IsLeader())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	first := NewFunc(UI, WithInfo(DefaultTaskInfo("first")))
+	second := NewFunc(UI, WithInfo(DefaultTaskInfo("second")))
+	leaderWheel, followerWheel := newWheel(leader), newWheel(follower)
+	leaderWheel.Start(ctx)
+	followerWheel.Start(ctx)
+	leaderWheel.AddTimer(DelayTask{Delay: 20 * time.Millisecond, Task: first})
+	followerWheel.AddTimer(DelayTask{Delay: 20 * time.Millisecond, Task: first})
+	// 领导者在 second 到期前退出
+	followerWheel.AddTimer(DelayTask{Delay: 20 * time.Millisecond, Task: second})
+	time.Sleep(50 * time.Millisecond)
+	leaderWheel.Stop()
+
+	// 租约过期后跟随者成为领导者，触发前任未触发的定时器
+	assert.Eventually(t, func() bool {
+		follower.campaign(ctx)
+		return follower.IsLeader()
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, map[string]int{"first": 1, "second": 1}, fired)
+}
@@ -0,0 +1,246 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/multierr"
+)
+
+// MapItem is the outcome of one item of a map task, Outputs holds what its task recorded with SetOutput
+type MapItem struct {
+	Index   int                    `json:"index"`
+	Item    interface{}            `json:"item"`
+	State   State                  `json:"state"`
+	Error   string                 `json:"error,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	err     error
+}
+
+// MapReport collects the outcome of every item of a map task, in the order of the items
+type MapReport struct {
+	Items     []MapItem `json:"items"`
+	Succeeded int       `json:"succeeded"`
+	Failed    int       `json:"failed"`
+}
+
+// Err combines the errors of the failed items
+func (r *MapReport) Err() error {
+	var err error
+	for _, item := range r.Items {
+		err = multierr.Append(err, item.err)
+	}
+	return err
+}
+
+// NewMapTask fans out over the items produced from the input, the MapReport is recorded
+// as the output named after the task. Every item records its outputs in a scope of its own.
+func NewMapTask(items func(ctx context.Context, input interface{}) ([]interface{}, error),
+	opts ...Option) *noopMapTask {
+	opt := &options{
+		info: DefaultTaskInfo(""),
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
+	// 初始化状态
+	if opt.info.Name() == "" {
+		opt.info.SetName("map-task")
+	}
+	opt.info.SetState(Ready)
+	opt.info.SetDescription("map task")
+	return &noopMapTask{
+		Info:        opt.info,
+		items:       items,
+		concurrency: 1,
+		callbacks:   opt.callbacks,
+	}
+}
+
+type noopMapTask struct {
+	Info
+	items       func(ctx context.Context, input interface{}) ([]interface{}, error)
+	concurrency int
+	tolerance   float64
+	callbacks   []Callback
+}
+
+// WithConcurrency set how many items run at the same time
+func (n *noopMapTask) WithConcurrency(concurrency int) *noopMapTask {
+	if concurrency > 0 {
+		n.concurrency = concurrency
+	}
+	return n
+}
+
+// WithTolerance set the ratio of failed items the task tolerates before it fails
+func (n *noopMapTask) WithTolerance(ratio float64) *noopMapTask {
+	n.tolerance = ratio
+	return n
+}
+
+// WithTask runs the task built by newTask for every item, the item is its input
+func (n *noopMapTask) WithTask(newTask func(item interface{}) Task) Task {
+	return &mapTask{
+		noopMapTask: n,
+		newTask:     newTask,
+	}
+}
+
+// WithTCC runs Try and then Confirm, or Cancel when Try failed, of the TCC built by newTCC for every item
+func (n *noopMapTask) WithTCC(newTCC func(item interface{}) TCC) Task {
+	return n.WithTask(func(item interface{}) Task {
+		return NewTCCTask(newTCC(item)).Strict()
+	})
+}
+
+type mapTask struct {
+	*noopMapTask
+	newTask func(item interface{}) Task
+}
+
+func (m *mapTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, m)
+	info.SetState(Running)
+	items, err := m.items(ctx, input)
+	if err == nil {
+		report := m.run(ctx, items)
+		SetOutput(ctx, m.Name(), report)
+		// 失败比例超过容忍度时任务失败
+		if float64(report.Failed) > m.tolerance*float64(len(items)) {
+			err = report.Err()
+		}
+	}
+	info.AddError(err)
+	markCancelled(ctx, info)
+	for _, callback := range m.callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	for _, callback := range callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	return err
+}
+
+func (m *mapTask) run(ctx context.Context, items []interface{}) *MapReport {
+	report := &MapReport{Items: make([]MapItem, len(items))}
+	allowed := int(m.tolerance * float64(len(items)))
+	newCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		sem   = make(chan struct{}, m.concurrency)
+	)
+	for index, item := range items {
+		report.Items[index] = MapItem{Index: index, Item: item, State: Ready}
+		select {
+		case <-newCtx.Done():
+			// 已失败过多或已取消，不再启动剩余的项
+			report.Items[index].State = Cancelled
+			report.Items[index].err = fmt.Errorf("item %d: %w", index, newCtx.Err())
+			report.Items[index].Error = report.Items[index].err.Error()
+			continue
+		case sem <- struct{}{}:
+		}
+		// 在启动协程前调度，保证子步骤顺序确定
+		task := m.newTask(item)
+		taskCtx := link(newCtx, m, task)
+		wg.Add(1)
+		go func(index int, item interface{}, task Task) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			// 每一项单独记录输出，避免同名输出互相覆盖
+			itemCtx, scope := withOutputScope(taskCtx)
+			run, err := Run(itemCtx, task, item)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			report.Items[index].State = run.State()
+			if values := scope.own(); len(values) > 0 {
+				report.Items[index].Outputs = values
+			}
+			if err != nil {
+				report.Items[index].err = fmt.Errorf("item %d: %w", index, err)
+				report.Items[index].Error = report.Items[index].err.Error()
+				report.Failed++
+				if report.Failed > allowed {
+					cancel()
+				}
+				return
+			}
+			report.Succeeded++
+		}(index, item, task)
+	}
+	wg.Wait()
+	// 未启动的项同样计为失败
+	report.Failed = len(items) - report.Succeeded
+	return report
+}
+
+// NewReduceTask combines the MapReport recorded by the map task named mapName,
+// the result is recorded as the output named after the reduce task
+func NewReduceTask(mapName string, reduce func(ctx context.Context, report *MapReport) (interface{}, error),
+	opts ...Option) Task {
+	opt := &options{
+		info: DefaultTaskInfo(""),
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
+	// 初始化状态
+	if opt.info.Name() == "" {
+		opt.info.SetName(fmt.Sprintf("reduce-%s", mapName))
+	}
+	opt.info.SetState(Ready)
+	opt.info.SetDescription("reduce task")
+	return &reduceTask{
+		Info:      opt.info,
+		mapName:   mapName,
+		reduce:    reduce,
+		callbacks: opt.callbacks,
+	}
+}
+
+type reduceTask struct {
+	Info
+	mapName   string
+	reduce    func(ctx context.Context, report *MapReport) (interface{}, error)
+	callbacks []Callback
+}
+
+func (r *reduceTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, r)
+	info.SetState(Running)
+	var err error
+	v, _ := Output(ctx, r.mapName)
+	if report, ok := v.(*MapReport); ok {
+		var result interface{}
+		if result, err = r.reduce(ctx, report); err == nil {
+			SetOutput(ctx, r.Name(), result)
+		}
+	} else {
+		err = fmt.Errorf("map task %q has no report", r.mapName)
+	}
+	info.AddError(err)
+	markCancelled(ctx, info)
+	for _, callback := range r.callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	for _, callback := range callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	return err
+}
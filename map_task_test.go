@@ -0,0 +1,90 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hosts(n int) func(context.Context, interface{}) ([]interface{}, error) {
+	return func(context.Context, interface{}) ([]interface{}, error) {
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			items = append(items, fmt.Sprintf("host-%d", i))
+		}
+		return items, nil
+	}
+}
+
+func TestMapTask(t *testing.T) {
+	var running, peak int32
+	mapTask := NewMapTask(hosts(50)).
+		WithConcurrency(5).
+		WithTolerance(0.1).
+		WithTask(func(item interface{}) Task {
+			return NewFunc(func(ctx context.Context, input interface{}) error {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				if input == "host-7" {
+					return fmt.Errorf("unreachable")
+				}
+				SetOutput(ctx, "host", input)
+				return nil
+			})
+		})
+	var count interface{}
+	pipeline := NewTaskPipeline().WithTasks(mapTask,
+		NewReduceTask("map-task", func(ctx context.Context, report *MapReport) (interface{}, error) {
+			return report.Succeeded, nil
+		}),
+		NewFunc(func(ctx context.Context, i interface{}) error {
+			count, _ = Output(ctx, "reduce-map-task")
+			return nil
+		}))
+
+	ctx := WithOutputs(context.Background())
+	require.NoError(t, pipeline.Execute(ctx, nil))
+	assert.Equal(t, 49, count)
+	assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(5))
+
+	v, _ := Output(ctx, "map-task")
+	report := v.(*MapReport)
+	assert.Equal(t, 1, report.Failed)
+	assert.Equal(t, Error, report.Items[7].State)
+	assert.Equal(t, "item 7: unreachable", report.Items[7].Error)
+	assert.Equal(t, Success, report.Items[8].State)
+	assert.Equal(t, map[string]interface{}{"host": "host-8"}, report.Items[8].Outputs)
+	assert.Empty(t, report.Items[7].Outputs)
+	_, ok := Output(ctx, "host")
+	assert.False(t, ok)
+}
+
+func TestMapTaskIntolerable(t *testing.T) {
+	var started int32
+	task := NewMapTask(hosts(20)).WithTask(func(item interface{}) Task {
+		return NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(&started, 1)
+			return fmt.Errorf("down")
+		})
+	})
+	ctx := WithOutputs(context.Background())
+	run, err := Run(ctx, task, nil)
+	assert.Error(t, err)
+	assert.Equal(t, Error, run.State())
+	// 超出容忍度后不再启动剩余的项
+	assert.Less(t, atomic.LoadInt32(&started), int32(20))
+	v, _ := Output(ctx, "map-task")
+	assert.Equal(t, 20, v.(*MapReport).Failed)
+}
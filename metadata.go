@@ -0,0 +1,178 @@
+package workflow
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Meta is the structured metadata of an info, Info.Metadata keeps it as a json object
+type Meta map[string]json.RawMessage
+
+// MetaOf decodes the metadata of info, metadata that is not a json object gives an empty Meta
+func MetaOf(info Info) Meta {
+	m := Meta{}
+	if data := info.Metadata(); len(data) > 0 {
+		if err := json.Unmarshal(data, &m); err != nil || m == nil {
+			return Meta{}
+		}
+	}
+	return m
+}
+
+// Set encodes value as the value of key
+func (m Meta) Set(key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	m[key] = data
+	return nil
+}
+
+// Get decodes the value of key into value, ok is false when key is missing
+func (m Meta) Get(key string, value interface{}) (ok bool, err error) {
+	data, ok := m[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, value)
+}
+
+// String returns the value of key, empty when it is missing or not a string
+func (m Meta) String(key string) string {
+	var v string
+	_, _ = m.Get(key, &v)
+	return v
+}
+
+// Int returns the value of key, 0 when it is missing or not an integer
+func (m Meta) Int(key string) int64 {
+	var v int64
+	_, _ = m.Get(key, &v)
+	return v
+}
+
+// Float returns the value of key, 0 when it is missing or not a number
+func (m Meta) Float(key string) float64 {
+	var v float64
+	_, _ = m.Get(key, &v)
+	return v
+}
+
+// Bool returns the value of key, false when it is missing or not a boolean
+func (m Meta) Bool(key string) bool {
+	var v bool
+	_, _ = m.Get(key, &v)
+	return v
+}
+
+// Time returns the value of key, zero when it is missing or not a RFC 3339 time
+func (m Meta) Time(key string) time.Time {
+	var v time.Time
+	_, _ = m.Get(key, &v)
+	return v
+}
+
+// Save stores m as the metadata of info
+func (m Meta) Save(info Info) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	info.SetMetadata(data)
+	return nil
+}
+
+// SetMeta sets key in the metadata of info, concurrent calls on the same info may lose updates
+func SetMeta(info Info, key string, value interface{}) error {
+	m := MetaOf(info)
+	if err := m.Set(key, value); err != nil {
+		return err
+	}
+	return m.Save(info)
+}
+
+// setMetadata merges the fields of v, encoded as a json object, into the metadata of info
+func setMetadata(info Info, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	var fields Meta
+	if err = json.Unmarshal(data, &fields); err != nil {
+		info.SetMetadata(data)
+		return
+	}
+	m := MetaOf(info)
+	for k, field := range fields {
+		m[k] = field
+	}
+	_ = m.Save(info)
+}
+
+// WithLabels sets labels on the info, the children of a composite inherit the labels they don't set
+func WithLabels(labels map[string]string) Option {
+	return labelsOption{labels}
+}
+
+type labelsOption struct {
+	labels map[string]string
+}
+
+func (l labelsOption) apply(opts *options) {
+	if opts.labels == nil {
+		opts.labels = make(map[string]string, len(l.labels))
+	}
+	for k, v := range l.labels {
+		opts.labels[k] = v
+	}
+}
+
+// WithMeta sets key in the metadata of the info, a value that cannot be encoded as json is dropped
+// and its error logged
+func WithMeta(key string, value interface{}) Option {
+	data, err := json.Marshal(value)
+	return metaOption{key: key, value: data, err: err}
+}
+
+type metaOption struct {
+	key   string
+	value json.RawMessage
+	err   error
+}
+
+func (m metaOption) apply(opts *options) {
+	if m.err != nil {
+		log.Printf("workflow: metadata %q dropped: %v", m.key, m.err)
+		return
+	}
+	if opts.meta == nil {
+		opts.meta = Meta{}
+	}
+	opts.meta[m.key] = m.value
+}
+
+// annotate sets the labels and metadata of the options on their info
+func (o *options) annotate() {
+	for k, v := range o.labels {
+		o.info.SetLabel(k, v)
+	}
+	if len(o.meta) > 0 {
+		m := MetaOf(o.info)
+		for k, v := range o.meta {
+			m[k] = v
+		}
+		_ = m.Save(o.info)
+	}
+}
+
+// matchLabels reports whether labels has every label of selector
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMeta(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	task := NewFunc(UI, WithMeta("owner", "billing"), WithMeta("priority", 3), WithMeta("due", at))
+	require.NoError(t, SetMeta(task, "dry_run", true))
+
+	meta := MetaOf(task)
+	assert.Equal(t, "billing", meta.String("owner"))
+	assert.Equal(t, int64(3), meta.Int("priority"))
+	assert.Equal(t, 3.0, meta.Float("priority"))
+	assert.True(t, meta.Bool("dry_run"))
+	assert.Equal(t, at, meta.Time("due"))
+	assert.Empty(t, meta.String("priority"))
+	ok, err := meta.Get("missing", new(string))
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.JSONEq(t, `{"owner":"billing","priority":3,"due":"2024-01-02T03:04:05Z","dry_run":true}`,
+		string(task.Metadata()))
+
+	// 内置的元数据与自定义的合并
+	loop := NewWhileTask(func(context.Context, interface{}) bool { return false }, WithMeta("owner", "billing")).
+		WithTask(NewFunc(UI))
+	run, err := Run(context.Background(), loop, nil)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"owner":"billing","iterations":0}`, string(run.Metadata()))
+
+	raw := DefaultTaskInfo("")
+	raw.SetMetadata([]byte("raw"))
+	assert.Empty(t, MetaOf(raw))
+
+	// 无法编码的值被丢弃并记录日志
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	task = NewFunc(UI, WithMeta("callback", func() {}), WithMeta("owner", "billing"))
+	assert.JSONEq(t, `{"owner":"billing"}`, string(task.Metadata()))
+	assert.Contains(t, logs.String(), `workflow: metadata "callback" dropped: json: unsupported type: func()`)
+}
+
+func TestLabels(t *testing.T) {
+	var labels map[string]string
+	child := NewFunc(func(ctx context.Context, _ interface{}) error {
+		labels = RunInfo(ctx).Labels()
+		return nil
+	}, WithLabels(map[string]string{"step": "charge", "team": "payments"}))
+	pipeline := NewTaskPipeline(WithLabels(map[string]string{"tenant": "acme", "team": "orders"})).WithTasks(child)
+
+	require.NoError(t, pipeline.Execute(context.Background(), nil))
+	assert.Equal(t, map[string]string{"step": "charge", "team": "payments", "tenant": "acme"}, labels)
+
+	// 共享的 TCC 只继承当前父节点的标签
+	shared := NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(UI), WithLabels(map[string]string{"step": "reserve"}))
+	orders := NewTCCGroup(WithLabels(map[string]string{"team": "orders"})).WithTCCs(shared)
+	billing := NewTCCGroup(WithLabels(map[string]string{"tenant": "acme"})).WithTCCs(shared)
+	require.NoError(t, orders.Try(context.Background(), nil))
+	assert.Equal(t, map[string]string{"step": "reserve", "team": "orders"}, shared.Labels())
+	require.NoError(t, billing.Try(context.Background(), nil))
+	assert.Equal(t, map[string]string{"step": "reserve", "tenant": "acme"}, shared.Labels())
+
+	hub := NewEventHub(16)
+	watched := NewFunc(UI, WithEventHub(hub), WithLabels(map[string]string{"tenant": "acme"}))
+	sub := hub.Subscribe(watched.ID(), 0)
+	defer sub.Close()
+	require.NoError(t, watched.Execute(context.Background(), nil))
+	e := <-sub.Events()
+	assert.Equal(t, map[string]string{"tenant": "acme"}, e.Labels)
+}
+
+func TestRunningWith(t *testing.T) {
+	r := NewRegistry()
+	release := make(chan struct{})
+	blocked := func(tenant string) Task {
+		return NewFunc(func(context.Context, interface{}) error {
+			<-release
+			return nil
+		}, WithLabels(map[string]string{"tenant": tenant}))
+	}
+	done := make(chan error, 2)
+	go func() { done <- r.Execute(context.Background(), "a", blocked("acme"), nil) }()
+	go func() { done <- r.Execute(context.Background(), "b", blocked("globex"), nil) }()
+	assert.Eventually(t, func() bool { return len(r.Running()) == 2 }, time.Second, time.Millisecond)
+
+	assert.Equal(t, []string{"a"}, r.RunningWith(map[string]string{"tenant": "acme"}))
+	assert.Empty(t, r.RunningWith(map[string]string{"tenant": "initech"}))
+	assert.Len(t, r.RunningWith(nil), 2)
+	close(release)
+	require.NoError(t, <-done)
+	require.NoError(t, <-done)
+}
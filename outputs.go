@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+)
+
+type outputsKey struct{}
+
+type outputs struct {
+	mutex  sync.RWMutex
+	values map[string]interface{}
+	parent *outputs // 外层作用域，读取时回退到它
+}
+
+// WithOutputs returns a context collecting the outputs set by the steps run with it,
+// every registry execution starts with its own outputs
+func WithOutputs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, outputsKey{}, &outputs{values: make(map[string]interface{})})
+}
+
+// withOutputScope returns a context whose outputs are recorded apart from the ones of ctx,
+// the outputs of ctx stay readable through it
+func withOutputScope(ctx context.Context) (context.Context, *outputs) {
+	o := &outputs{values: make(map[string]interface{})}
+	o.parent, _ = ctx.Value(outputsKey{}).(*outputs)
+	return context.WithValue(ctx, outputsKey{}, o), o
+}
+
+// own returns a copy of the outputs recorded in o itself
+func (o *outputs) own() map[string]interface{} {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+	values := make(map[string]interface{}, len(o.values))
+	for k, v := range o.values {
+		values[k] = v
+	}
+	return values
+}
+
+// SetOutput records the output of a step, it is dropped when ctx does not collect outputs
+func SetOutput(ctx context.Context, key string, value interface{}) {
+	o, ok := ctx.Value(outputsKey{}).(*outputs)
+	if !ok {
+		return
+	}
+	o.set(key, value)
+}
+
+func (o *outputs) set(key string, value interface{}) {
+	o.mutex.Lock()
+	o.values[key] = value
+	o.mutex.Unlock()
+}
+
+// Output returns the output recorded as key
+func Output(ctx context.Context, key string) (interface{}, bool) {
+	o, ok := ctx.Value(outputsKey{}).(*outputs)
+	if !ok {
+		return nil, false
+	}
+	for ; o != nil; o = o.parent {
+		o.mutex.RLock()
+		v, ok := o.values[key]
+		o.mutex.RUnlock()
+		if ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// Outputs returns a copy of the outputs recorded with ctx, those of an inner scope
+// override the outer ones
+func Outputs(ctx context.Context) map[string]interface{} {
+	o, ok := ctx.Value(outputsKey{}).(*outputs)
+	if !ok {
+		return make(map[string]interface{})
+	}
+	if o.parent == nil {
+		return o.own()
+	}
+	values := Outputs(context.WithValue(ctx, outputsKey{}, o.parent))
+	for k, v := range o.own() {
+		values[k] = v
+	}
+	return values
+}
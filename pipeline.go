@@ -1,6 +1,9 @@
 package workflow
 
-import "context"
+import (
+	"context"
+	"errors"
+)
 
 func NewTaskPipeline(opts ...Option) *noopTaskPipeline {
 	opt := &options{
@@ -9,6 +12,10 @@ func NewTaskPipeline(opts ...Option) *noopTaskPipeline {
 	for _, o := range opts {
 		o.apply(opt)
 	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
 	// 初始化状态
 	opt.info.SetName("task-pipeline")
 	opt.info.SetState(Ready)
@@ -36,19 +43,52 @@ type taskPipeline struct {
 	tasks []Task
 }
 
+func (t *taskPipeline) children() []Info {
+	children := make([]Info, 0, len(t.tasks))
+	for _, task := range t.tasks {
+		children = append(children, task)
+	}
+	return children
+}
+
 func (t *taskPipeline) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	for _, tempTask := range t.tasks {
-		if err := tempTask.Execute(ctx, input); err != nil {
-			t.AddError(err)
+	ctx, info := startRun(ctx, t)
+	info.SetState(Running)
+	var suspended bool
+	for index := startStep(ctx, t); index < len(t.tasks); index++ {
+		// 步骤之间检查执行是否已被取消或暂停
+		if err := ctx.Err(); err != nil {
+			info.AddError(err)
+			break
+		}
+		if err := stepBoundary(ctx, t, index); err != nil {
+			info.AddError(err)
+			break
+		}
+		if err := t.tasks[index].Execute(link(ctx, t, t.tasks[index]), input); err != nil {
+			if errors.Is(err, ErrSuspended) {
+				suspended = true
+				info.SetState(Waiting)
+				break
+			}
+			info.AddError(err)
 			break
 		}
 	}
-	err := t.Error()
+	if !suspended {
+		info.AddError(nil)
+	}
+	stepsDone(ctx, t)
+	markCancelled(ctx, info)
+	err := info.Error()
+	if suspended {
+		err = ErrSuspended
+	}
 	for _, callback := range t.callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
@@ -0,0 +1,134 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrNotInflight is returned when extending a message that is not delivered any more,
+// it was acknowledged or its visibility timed out
+var ErrNotInflight = errors.New("message is not in flight")
+
+// Message is a task run transferred through a Queue
+type Message struct {
+	ID      string          `json:"id"`
+	Task    string          `json:"task,omitempty"`
+	Input   json.RawMessage `json:"input,omitempty"`
+	ReplyTo string          `json:"reply_to,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Attempt int             `json:"attempt"`
+}
+
+// Queue is an at-least-once message queue.
+// A dequeued message is invisible to other consumers for the visibility timeout,
+// it is delivered again if it has not been acknowledged by then.
+type Queue interface {
+	Enqueue(ctx context.Context, queue string, msg *Message) error
+	Dequeue(ctx context.Context, queue string, visibility time.Duration) (*Message, error)
+	// Extend keeps a dequeued message invisible for visibility from now on, for long runs
+	Extend(ctx context.Context, queue string, id string, visibility time.Duration) error
+	Ack(ctx context.Context, queue string, id string) error
+}
+
+const defaultPollInterval = 100 * time.Millisecond
+
+// NewMemoryQueue returns a Queue kept in process memory
+func NewMemoryQueue() *memoryQueue {
+	return &memoryQueue{
+		queues: make(map[string]*memoryQueueEntry),
+		notify: make(chan struct{}),
+	}
+}
+
+type memoryQueue struct {
+	mutex  sync.Mutex
+	queues map[string]*memoryQueueEntry
+	notify chan struct{} // 有新消息时关闭并重建，用于唤醒等待者
+}
+
+type memoryQueueEntry struct {
+	ready    []*Message
+	inflight map[string]*inflightMessage
+}
+
+type inflightMessage struct {
+	msg      *Message
+	deadline time.Time
+}
+
+func (m *memoryQueue) entry(queue string) *memoryQueueEntry {
+	e, ok := m.queues[queue]
+	if !ok {
+		e = &memoryQueueEntry{inflight: make(map[string]*inflightMessage)}
+		m.queues[queue] = e
+	}
+	return e
+}
+
+func (m *memoryQueue) Enqueue(_ context.Context, queue string, msg *Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	e := m.entry(queue)
+	temp := *msg
+	e.ready = append(e.ready, &temp)
+	close(m.notify)
+	m.notify = make(chan struct{})
+	return nil
+}
+
+func (m *memoryQueue) Dequeue(ctx context.Context, queue string, visibility time.Duration) (*Message, error) {
+	ticker := time.NewTicker(defaultPollInterval)
+	defer ticker.Stop()
+	for {
+		m.mutex.Lock()
+		e := m.entry(queue)
+		now := time.Now()
+		// 超时未确认的消息重新投递
+		for id, in := range e.inflight {
+			if now.After(in.deadline) {
+				delete(e.inflight, id)
+				e.ready = append([]*Message{in.msg}, e.ready...)
+			}
+		}
+		if len(e.ready) > 0 {
+			msg := e.ready[0]
+			e.ready = e.ready[1:]
+			msg.Attempt++
+			e.inflight[msg.ID] = &inflightMessage{msg: msg, deadline: now.Add(visibility)}
+			m.mutex.Unlock()
+			temp := *msg
+			return &temp, nil
+		}
+		notify := m.notify
+		m.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *memoryQueue) Extend(_ context.Context, queue string, id string, visibility time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	in, ok := m.entry(queue).inflight[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotInflight, id)
+	}
+	in.deadline = time.Now().Add(visibility)
+	return nil
+}
+
+func (m *memoryQueue) Ack(_ context.Context, queue string, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.entry(queue).inflight, id)
+	return nil
+}
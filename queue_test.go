@@ -0,0 +1,151 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testQueueRedelivery(t *testing.T, q Queue) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, "jobs", &Message{ID: "1", Task: "echo"}))
+
+	msg, err := q.Dequeue(ctx, "jobs", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "1", msg.ID)
+	assert.Equal(t, 1, msg.Attempt)
+
+	// 未确认的消息在可见性超时后重新投递
+	msg, err = q.Dequeue(ctx, "jobs", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "1", msg.ID)
+	assert.Equal(t, 2, msg.Attempt)
+	require.NoError(t, q.Ack(ctx, "jobs", msg.ID))
+	assert.ErrorIs(t, q.Extend(ctx, "jobs", msg.ID, time.Minute), ErrNotInflight)
+
+	// 延长可见性的消息不会被重新投递
+	require.NoError(t, q.Enqueue(ctx, "jobs", &Message{ID: "2", Task: "echo"}))
+	msg, err = q.Dequeue(ctx, "jobs", 50*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, q.Extend(ctx, "jobs", msg.ID, time.Minute))
+	time.Sleep(100 * time.Millisecond)
+
+	short, shortCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer shortCancel()
+	_, err = q.Dequeue(short, "jobs", time.Minute)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMemoryQueue(t *testing.T) {
+	testQueueRedelivery(t, NewMemoryQueue())
+}
+
+func TestQueueExecutor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q := NewMemoryQueue()
+
+	w := NewWorker(q, "jobs", WithConcurrency(2))
+	w.Register("double", NewFunc(func(ctx context.Context, input interface{}) error {
+		var v int
+		if err := json.Unmarshal(input.(json.RawMessage), &v); err != nil {
+			return err
+		}
+		if v < 0 {
+			return errors.New("negative")
+		}
+		return nil
+	}))
+	go func() { _ = w.Run(ctx) }()
+
+	e := NewQueueExecutor(q, "jobs")
+	go func() { _ = e.Run(ctx) }()
+
+	task := e.Task("double")
+	run, err := Run(ctx, task, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, Success, run.State())
+
+	run, err = Run(ctx, task, -1)
+	assert.EqualError(t, err, "negative")
+	assert.Equal(t, Error, run.State())
+
+	assert.EqualError(t, e.Task("missing").Execute(ctx, 1), `task "missing" is not registered`)
+}
+
+// flakyQueue fails the first acknowledgement
+type flakyQueue struct {
+	Queue
+	failed int32
+}
+
+func (f *flakyQueue) Ack(ctx context.Context, queue string, id string) error {
+	if atomic.CompareAndSwapInt32(&f.failed, 0, 1) {
+		return errors.New("ack failed")
+	}
+	return f.Queue.Ack(ctx, queue, id)
+}
+
+func TestWorkerKeepsRunning(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q := &flakyQueue{Queue: NewMemoryQueue()}
+	var (
+		runs   int32
+		errs   = make(chan error, 4)
+		extend = make(chan struct{})
+	)
+	w := NewWorker(q, "jobs", WithConcurrency(2), WithVisibility(40*time.Millisecond), WithErrorHandler(func(msg *Message, err error) {
+		errs <- err
+	}))
+	w.Register("slow", NewFunc(func(ctx context.Context, input interface{}) error {
+		atomic.AddInt32(&runs, 1)
+		select {
+		case <-extend:
+		case <-time.After(150 * time.Millisecond):
+			// 运行超过可见性超时，由 worker 延长
+			close(extend)
+		}
+		return nil
+	}))
+	go func() { _ = w.Run(ctx) }()
+
+	require.NoError(t, q.Enqueue(ctx, "jobs", &Message{ID: "1", Task: "slow"}))
+	assert.EqualError(t, <-errs, "ack failed")
+	// 确认失败后 worker 继续运行，消息重新投递一次
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&runs) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&runs))
+}
+
+func TestWorkerVisibility(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q := NewMemoryQueue()
+	// 非正数的可见性超时使用默认值
+	w := NewWorker(q, "jobs", WithVisibility(0))
+	assert.Equal(t, defaultVisibility, w.visibility)
+	assert.Equal(t, defaultVisibility, NewQueueExecutor(q, "jobs", WithVisibility(-time.Second)).visibility)
+
+	done := make(chan struct{})
+	w.Register("job", NewFunc(func(context.Context, interface{}) error {
+		close(done)
+		return nil
+	}))
+	go func() { _ = w.Run(ctx) }()
+	require.NoError(t, q.Enqueue(ctx, "jobs", &Message{ID: "1", Task: "job"}))
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal("the job did not run")
+	}
+}
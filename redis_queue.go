@@ -0,0 +1,286 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// NewRedisQueue returns a Queue stored in a redis server, speaking the RESP protocol directly.
+// For every queue it keeps the message bodies in the hash "<prefix>:<queue>:messages",
+// the ready ids in the list "<prefix>:<queue>:ready", the delivered ids in the
+// sorted set "<prefix>:<queue>:inflight" scored by their visibility deadline and the
+// delivery counts in the hash "<prefix>:<queue>:attempts". It needs redis 2.6 or later for EVAL.
+func NewRedisQueue(addr, prefix string) *redisQueue {
+	if prefix == "" {
+		prefix = "workflow"
+	}
+	return &redisQueue{
+		client: &redisClient{addr: addr},
+		prefix: prefix,
+	}
+}
+
+type redisQueue struct {
+	client *redisClient
+	prefix string
+}
+
+func (r *redisQueue) key(queue, kind string) string {
+	return fmt.Sprintf("%s:%s:%s", r.prefix, queue, kind)
+}
+
+// 出队、超时重投和确认各自在一个脚本中原子执行，消费者在任意两条命令之间退出都不会丢失消息
+const (
+	// KEYS: ready, inflight, messages, attempts  ARGV: visibility deadline
+	redisPopScript = `while true do
+	local id = redis.call('RPOP', KEYS[1])
+	if not id then
+		return false
+	end
+	local data = redis.call('HGET', KEYS[3], id)
+	if data then
+		redis.call('ZADD', KEYS[2], ARGV[1], id)
+		return {data, redis.call('HINCRBY', KEYS[4], id, 1)}
+	end
+end`
+	// KEYS: inflight, ready, messages  ARGV: now
+	redisRequeueScript = `local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(ids) do
+	redis.call('ZREM', KEYS[1], id)
+	if redis.call('HEXISTS', KEYS[3], id) == 1 then
+		redis.call('RPUSH', KEYS[2], id)
+	end
+end
+return #ids`
+	// KEYS: inflight, messages, attempts  ARGV: id
+	redisAckScript = `redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return redis.call('HDEL', KEYS[2], ARGV[1])`
+	// KEYS: inflight  ARGV: visibility deadline, id
+	redisExtendScript = `if not redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+return 1`
+)
+
+func (r *redisQueue) Enqueue(ctx context.Context, queue string, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err = r.client.Do(ctx, "HSET", r.key(queue, "messages"), msg.ID, string(data)); err != nil {
+		return err
+	}
+	_, err = r.client.Do(ctx, "LPUSH", r.key(queue, "ready"), msg.ID)
+	return err
+}
+
+func (r *redisQueue) Dequeue(ctx context.Context, queue string, visibility time.Duration) (*Message, error) {
+	ticker := time.NewTicker(defaultPollInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.requeueExpired(ctx, queue); err != nil {
+			return nil, err
+		}
+		msg, err := r.pop(ctx, queue, visibility)
+		if err != nil {
+			return nil, err
+		}
+		if msg != nil {
+			return msg, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *redisQueue) pop(ctx context.Context, queue string, visibility time.Duration) (*Message, error) {
+	deadline := time.Now().Add(visibility).UnixMilli()
+	reply, err := r.client.Do(ctx, "EVAL", redisPopScript, "4",
+		r.key(queue, "ready"), r.key(queue, "inflight"), r.key(queue, "messages"), r.key(queue, "attempts"),
+		strconv.FormatInt(deadline, 10))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	if len(values) != 2 {
+		return nil, fmt.Errorf("redis: unexpected dequeue reply %v", reply)
+	}
+	data, _ := values[0].(string)
+	var msg Message
+	if err = json.Unmarshal([]byte(data), &msg); err != nil {
+		return nil, err
+	}
+	attempt, _ := values[1].(int64)
+	msg.Attempt = int(attempt)
+	return &msg, nil
+}
+
+// requeueExpired moves the messages whose visibility deadline passed back to the ready list
+func (r *redisQueue) requeueExpired(ctx context.Context, queue string) error {
+	_, err := r.client.Do(ctx, "EVAL", redisRequeueScript, "3",
+		r.key(queue, "inflight"), r.key(queue, "ready"), r.key(queue, "messages"),
+		strconv.FormatInt(time.Now().UnixMilli(), 10))
+	return err
+}
+
+func (r *redisQueue) Extend(ctx context.Context, queue string, id string, visibility time.Duration) error {
+	deadline := time.Now().Add(visibility).UnixMilli()
+	reply, err := r.client.Do(ctx, "EVAL", redisExtendScript, "1", r.key(queue, "inflight"),
+		strconv.FormatInt(deadline, 10), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := reply.(int64); n == 0 {
+		return fmt.Errorf("%w: %s", ErrNotInflight, id)
+	}
+	return nil
+}
+
+func (r *redisQueue) Ack(ctx context.Context, queue string, id string) error {
+	_, err := r.client.Do(ctx, "EVAL", redisAckScript, "3",
+		r.key(queue, "inflight"), r.key(queue, "messages"), r.key(queue, "attempts"), id)
+	return err
+}
+
+func (r *redisQueue) Close() error {
+	return r.client.Close()
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redisClient is a minimal RESP client holding a single connection
+type redisClient struct {
+	addr   string
+	mutex  sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Do sends a command and returns its reply as nil, string, int64 or []interface{}
+func (c *redisClient) Do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", c.addr)
+		if err != nil {
+			return nil, err
+		}
+		c.conn = conn
+		c.reader = bufio.NewReader(conn)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = c.conn.SetDeadline(deadline)
+	} else {
+		_ = c.conn.SetDeadline(time.Time{})
+	}
+	reply, err := c.do(args)
+	var re redisError
+	if err != nil && !errors.As(err, &re) {
+		// 连接状态未知，丢弃后下次重连
+		_ = c.conn.Close()
+		c.conn = nil
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		var ne net.Error
+		if _, ok := ctx.Deadline(); ok && errors.As(err, &ne) && ne.Timeout() {
+			// 连接超时来自 ctx 的截止时间
+			return nil, context.DeadlineExceeded
+		}
+	}
+	return reply, err
+}
+
+func (c *redisClient) do(args []string) (interface{}, error) {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+func (c *redisClient) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: invalid reply %q", line)
+	}
+	line = line[:len(line)-2]
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
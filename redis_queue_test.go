@@ -0,0 +1,185 @@
+package workflow
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a local stand-in implementing the commands used by redisQueue
+type fakeRedis struct {
+	mutex  sync.Mutex
+	hashes map[string]map[string]string
+	lists  map[string][]string
+	zsets  map[string]map[string]float64
+}
+
+func newFakeRedis(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	f := &fakeRedis{
+		hashes: make(map[string]map[string]string),
+		lists:  make(map[string][]string),
+		zsets:  make(map[string]map[string]float64),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		values, _ := reply.([]interface{})
+		args := make([]string, 0, len(values))
+		for _, v := range values {
+			args = append(args, v.(string))
+		}
+		if _, err = conn.Write([]byte(f.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch strings.ToUpper(args[0]) {
+	case "HSET":
+		h, ok := f.hashes[args[1]]
+		if !ok {
+			h = make(map[string]string)
+			f.hashes[args[1]] = h
+		}
+		h[args[2]] = args[3]
+		return ":1\r\n"
+	case "LPUSH":
+		f.lists[args[1]] = append([]string{args[2]}, f.lists[args[1]]...)
+		return fmt.Sprintf(":%d\r\n", len(f.lists[args[1]]))
+	case "EVAL":
+		// 按脚本内容模拟 redisQueue 使用的脚本
+		n, _ := strconv.Atoi(args[2])
+		return f.eval(args[1], args[3:3+n], args[3+n:])
+	}
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func (f *fakeRedis) zset(key string) map[string]float64 {
+	z, ok := f.zsets[key]
+	if !ok {
+		z = make(map[string]float64)
+		f.zsets[key] = z
+	}
+	return z
+}
+
+func (f *fakeRedis) hash(key string) map[string]string {
+	h, ok := f.hashes[key]
+	if !ok {
+		h = make(map[string]string)
+		f.hashes[key] = h
+	}
+	return h
+}
+
+func (f *fakeRedis) eval(script string, keys, argv []string) string {
+	switch script {
+	case redisPopScript:
+		for {
+			l := f.lists[keys[0]]
+			if len(l) == 0 {
+				return "$-1\r\n"
+			}
+			id := l[len(l)-1]
+			f.lists[keys[0]] = l[:len(l)-1]
+			data, ok := f.hashes[keys[2]][id]
+			if !ok {
+				continue
+			}
+			score, _ := strconv.ParseFloat(argv[0], 64)
+			f.zset(keys[1])[id] = score
+			attempts := f.hash(keys[3])
+			attempt, _ := strconv.Atoi(attempts[id])
+			attempts[id] = strconv.Itoa(attempt + 1)
+			return fmt.Sprintf("*2\r\n%s:%d\r\n", bulk(data), attempt+1)
+		}
+	case redisRequeueScript:
+		max, _ := strconv.ParseFloat(argv[0], 64)
+		ids := make([]string, 0)
+		for id, score := range f.zsets[keys[0]] {
+			if score <= max {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			delete(f.zsets[keys[0]], id)
+			if _, ok := f.hashes[keys[2]][id]; ok {
+				f.lists[keys[1]] = append(f.lists[keys[1]], id)
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", len(ids))
+	case redisAckScript:
+		delete(f.zsets[keys[0]], argv[0])
+		delete(f.hashes[keys[2]], argv[0])
+		delete(f.hashes[keys[1]], argv[0])
+		return ":1\r\n"
+	case redisExtendScript:
+		if _, ok := f.zsets[keys[0]][argv[1]]; !ok {
+			return ":0\r\n"
+		}
+		score, _ := strconv.ParseFloat(argv[0], 64)
+		f.zsets[keys[0]][argv[1]] = score
+		return ":1\r\n"
+	}
+	return "-NOSCRIPT unknown script\r\n"
+}
+
+func TestRedisQueue(t *testing.T) {
+	q := NewRedisQueue(newFakeRedis(t), "test")
+	defer q.Close()
+	testQueueRedelivery(t, q)
+}
+
+func TestRedisQueueSkipsAcknowledged(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q := NewRedisQueue(newFakeRedis(t), "test")
+	defer q.Close()
+	require.NoError(t, q.Enqueue(ctx, "jobs", &Message{ID: "1"}))
+	require.NoError(t, q.Enqueue(ctx, "jobs", &Message{ID: "2"}))
+	// 已确认的消息在就绪列表中留下的 id 被丢弃，不会进入投递中集合
+	require.NoError(t, q.Ack(ctx, "jobs", "1"))
+
+	msg, err := q.Dequeue(ctx, "jobs", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "2", msg.ID)
+	assert.ErrorIs(t, q.Extend(ctx, "jobs", "1", time.Minute), ErrNotInflight)
+}
@@ -0,0 +1,186 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ErrRemote is returned when a remote participant or coordinator answers with an error status
+var ErrRemote = errors.New("remote call failed")
+
+// RemoteBranch locates the Try, Confirm and Cancel endpoints of a remote participant,
+// Input is the input of its Try that the coordinator passes to its Confirm or Cancel
+type RemoteBranch struct {
+	ID      string          `json:"id"`
+	Try     string          `json:"try"`
+	Confirm string          `json:"confirm"`
+	Cancel  string          `json:"cancel"`
+	Input   json.RawMessage `json:"input,omitempty"`
+}
+
+// NewRemoteTCC returns a TCC posting the json input to the phase endpoints of branch, the global transaction
+// is carried by TransactionHeader. With WithCoordinator the branch is registered under the global
+// transaction with the input of its Try, so that the coordinator can Confirm or Cancel it. The id of the branch
+// defaults to the id of the TCC.
+func NewRemoteTCC(branch RemoteBranch, opts ...Option) TCC {
+	opt := &options{
+		client: http.DefaultClient,
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	r := &remoteParticipant{
+		client:      opt.client,
+		coordinator: opt.coordinator,
+	}
+	tcc := NewTCC(NewFunc(r.try), NewFunc(r.call(branch.Confirm)), NewFunc(r.call(branch.Cancel)), opts...)
+	tcc.SetName("remote-tcc")
+	if branch.ID == "" {
+		branch.ID = tcc.ID()
+	}
+	r.branch = branch
+	return tcc
+}
+
+type remoteParticipant struct {
+	client      *http.Client
+	coordinator *CoordinatorClient
+	branch      RemoteBranch
+}
+
+func (r *remoteParticipant) try(ctx context.Context, input interface{}) error {
+	if r.coordinator != nil {
+		branch := r.branch
+		if input != nil {
+			data, err := json.Marshal(input)
+			if err != nil {
+				return err
+			}
+			branch.Input = data
+		}
+		if err := r.coordinator.Register(ctx, branch); err != nil {
+			return err
+		}
+	}
+	return r.call(r.branch.Try)(ctx, input)
+}
+
+func (r *remoteParticipant) call(url string) func(ctx context.Context, input interface{}) error {
+	return func(ctx context.Context, input interface{}) error {
+		return postJSON(ctx, r.client, url, input, nil)
+	}
+}
+
+// postJSON posts body as json to url in the global transaction of ctx and decodes the answer into out
+func postJSON(ctx context.Context, client *http.Client, url string, body, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	InjectTransaction(ctx, req.Header)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("%w: %s %s: %s", ErrRemote, url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// ParticipantHandler serves the phases of TCCs to remote callers: POST try, confirm and cancel.
+// The json body is the input, as a json.RawMessage, and the global transaction the one of TransactionHeader,
+// which is required. Each global transaction runs its own TCC, made by newTCC on its first phase and
+// forgotten once confirmed or cancelled, so with a barrier newTCC must give stable ids (see WithBarrier).
+// A Try rejected by the barrier of the TCC answers 409.
+func ParticipantHandler(newTCC func(gid string) TCC) http.Handler {
+	return &participantHandler{
+		newTCC: newTCC,
+		tccs:   make(map[string]TCC),
+	}
+}
+
+type participantHandler struct {
+	newTCC func(gid string) TCC
+	mutex  sync.Mutex
+	tccs   map[string]TCC // 全局事务 -> TCC
+}
+
+// tcc returns the TCC of the global transaction gid
+func (p *participantHandler) tcc(gid string) TCC {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	tcc, ok := p.tccs[gid]
+	if !ok {
+		tcc = p.newTCC(gid)
+		p.tccs[gid] = tcc
+	}
+	return tcc
+}
+
+// forget drops tcc once its global transaction gid ended
+func (p *participantHandler) forget(gid string, tcc TCC) {
+	p.mutex.Lock()
+	if p.tccs[gid] == tcc {
+		delete(p.tccs, gid)
+	}
+	p.mutex.Unlock()
+}
+
+func (p *participantHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	phase := Phase(strings.Trim(r.URL.Path, "/"))
+	if r.Method != http.MethodPost || (phase != PhaseTry && phase != PhaseConfirm && phase != PhaseCancel) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	ctx := ExtractTransaction(r.Context(), r.Header)
+	gid := TransactionID(ctx)
+	if gid == "" {
+		http.Error(w, "missing "+TransactionHeader, http.StatusBadRequest)
+		return
+	}
+	var input json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tcc := p.tcc(gid)
+	var err error
+	switch phase {
+	case PhaseTry:
+		err = tcc.Try(ctx, input)
+	case PhaseConfirm:
+		err = tcc.Confirm(ctx, input)
+	default:
+		err = tcc.Cancel(ctx, input)
+	}
+	// 事务结束后不再保留，被拒绝的 Try 说明事务已取消
+	if (phase != PhaseTry && err == nil) || errors.Is(err, ErrBarrierRejected) {
+		p.forget(gid, tcc)
+	}
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, ErrBarrierRejected):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
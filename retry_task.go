@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
+	"go.uber.org/multierr"
 )
 
 type retryTask struct {
@@ -14,6 +15,12 @@ type retryTask struct {
 	attempts int
 	interval time.Duration
 	policy   Policy
+	clock    Clock
+}
+
+// children of the retried task, which shares its info
+func (rt *retryTask) children() []Info {
+	return childrenOf(rt.Task)
 }
 
 func RetryTask(t Task, opts ...RetryOption) Task {
@@ -21,6 +28,7 @@ func RetryTask(t Task, opts ...RetryOption) Task {
 		attempts: defaultAttempt,
 		interval: defaultInterval,
 		policy:   PolicyRetry,
+		clock:    SystemClock,
 	}
 	for _, o := range opts {
 		o.apply(opt)
@@ -32,39 +40,46 @@ func RetryTask(t Task, opts ...RetryOption) Task {
 		attempts: opt.attempts,
 		interval: opt.interval,
 		policy:   opt.policy,
+		clock:    opt.clock,
 	}
 }
 
 func (rt *retryTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, rt)
+	info.SetState(Running)
+	// 每次尝试都是本次运行的子运行
 	err := rt.Task.Execute(ctx, input, callbacks...)
 	if err == nil {
+		info.AddError(nil)
 		return nil
 	}
-	rt.Task.SetState(Running)
 	if rt.policy == PolicyRetry {
 		var tempAttempts int
 		backOff := rt.newBackOff() // 退避算法 保证时间间隔为指数级增长
 		currentInterval := 0 * time.Millisecond
-		timer := time.NewTimer(currentInterval)
+		timer := rt.clock.NewTimer(currentInterval)
 		for {
 			select {
-			case <-timer.C:
+			case <-timer.C():
 				shouldRetry := tempAttempts < rt.attempts
 				if !shouldRetry {
 					timer.Stop()
-					rt.Task.AddError(err)
-					return rt.Task.Error()
+					info.AddError(err)
+					return info.Error()
 				}
-				if retryErr := rt.Task.Execute(ctx, input, callbacks...); retryErr == nil {
-					shouldRetry = false
-				} else {
-					rt.Task.SetState(Running)
+				if historyErr := recordHistory(ctx, rt.Task, HistoryRetried, err); historyErr != nil {
+					timer.Stop()
+					info.AddError(multierr.Append(err, historyErr))
+					return info.Error()
 				}
-				if !shouldRetry {
+				info.SetState(Retrying)
+				retryErr := rt.Task.Execute(ctx, input, callbacks...)
+				if retryErr == nil {
 					timer.Stop()
-					rt.Task.AddError(err)
-					return rt.Task.Error()
+					info.AddError(nil)
+					return nil
 				}
+				err = multierr.Append(err, retryErr)
 				// 计算下一次
 				currentInterval = backOff.NextBackOff()
 				tempAttempts++
@@ -72,12 +87,14 @@ func (rt *retryTask) Execute(ctx context.Context, input interface{}, callbacks .
 				timer.Reset(currentInterval)
 			case <-ctx.Done():
 				timer.Stop()
+				info.AddError(ctx.Err())
+				markCancelled(ctx, info)
 				return ctx.Err()
 			}
 		}
 	}
-	rt.Task.AddError(err)
-	return rt.Task.Error()
+	info.AddError(err)
+	return info.Error()
 }
 
 func (rt *retryTask) newBackOff() backoff.BackOff {
@@ -87,6 +104,7 @@ func (rt *retryTask) newBackOff() backoff.BackOff {
 
 	b := backoff.NewExponentialBackOff()
 	b.InitialInterval = rt.interval
+	b.Clock = rt.clock
 
 	// calculate the multiplier for the given number of attempts
 	// so that applying the multiplier for the given number of attempts will not exceed 2 times the initial interval
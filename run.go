@@ -0,0 +1,90 @@
+package workflow
+
+import "context"
+
+type runKey struct{}
+
+type runHookKey struct{}
+
+// RunInfo returns the info of the run executing in ctx, a task run or a TCC
+func RunInfo(ctx context.Context) Info {
+	if info, ok := ctx.Value(runKey{}).(Info); ok {
+		return info
+	}
+	return nil
+}
+
+func withRun(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, runKey{}, info)
+}
+
+// runOf returns the info of the run of def executing in ctx, def itself when it is not running
+func runOf(ctx context.Context, def Info) Info {
+	if run := RunInfo(ctx); run != nil && (run.ID() == def.ID() || run.DefinitionID() == def.ID()) {
+		return run
+	}
+	return def
+}
+
+// startRun creates the info of a new run of the task def, a child of the run executing in ctx.
+// The definition is left untouched, so a task can run concurrently.
+func startRun(ctx context.Context, def Info) (context.Context, Info) {
+	ctx, run := watch(ctx, def.NewRun())
+	parent := RunInfo(ctx)
+	if parent != nil {
+		run.SetParent(parent)
+		addChild(parent, run)
+	}
+	if h := historyFrom(ctx); h != nil {
+		ctx = h.bind(ctx, parent, run)
+	}
+	if id := ExecutionID(ctx); id != "" {
+		run.SetExecutionID(id)
+	}
+	if hook, ok := ctx.Value(runHookKey{}).(*Info); ok {
+		*hook = run
+		ctx = context.WithValue(ctx, runHookKey{}, nil)
+	}
+	return withRun(ctx, run), run
+}
+
+type phaseKey struct{}
+
+// phaseFrame is a TCC running a phase, the frames of ctx lead to the outermost TCC
+type phaseFrame struct {
+	tcc    TCC
+	info   Info
+	parent *phaseFrame
+}
+
+// startPhase prepares ctx for a phase of the TCC t, info is the own info of t.
+// The phase joins the global transaction of ctx, or the one of the last Try of t.
+// It returns the info the phase updates, watched by the event hub of ctx.
+// A phase retried by an operator starts over without the errors of the failed one.
+func startPhase(ctx context.Context, t TCC, info Info) (context.Context, Info) {
+	ctx, info = watch(joinTransaction(ctx, t), info)
+	parent, _ := ctx.Value(phaseKey{}).(*phaseFrame)
+	ctx = context.WithValue(ctx, phaseKey{}, &phaseFrame{tcc: t, info: info, parent: parent})
+	if intervened(ctx) {
+		clearError(info)
+	}
+	return withRun(ctx, info), info
+}
+
+// startTry prepares ctx for the Try of the TCC t like startPhase, a Try starts a new transaction
+// so the errors of the previous one are dropped
+func startTry(ctx context.Context, t TCC, info Info) (context.Context, Info) {
+	ctx, info = startPhase(startTransaction(ctx, t), t, info)
+	clearError(info)
+	return ctx, info
+}
+
+// Run executes t and returns the info of the run, t itself when it does not create runs
+func Run(ctx context.Context, t Task, input interface{}, callbacks ...Callback) (Info, error) {
+	var run Info
+	err := t.Execute(context.WithValue(ctx, runHookKey{}, &run), input, callbacks...)
+	if run == nil {
+		return t, err
+	}
+	return run, err
+}
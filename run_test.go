@@ -0,0 +1,49 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentRuns(t *testing.T) {
+	task := NewFunc(func(ctx context.Context, input interface{}) error {
+		if input.(int)%2 == 1 {
+			return errors.New("odd")
+		}
+		return nil
+	})
+	pipeline := NewTaskPipeline().WithTasks(task)
+
+	runs := make([]Info, 10)
+	var wg sync.WaitGroup
+	for i := range runs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runs[i], _ = Run(context.Background(), pipeline, i)
+		}(i)
+	}
+	wg.Wait()
+	for i, run := range runs {
+		assert.Equal(t, pipeline.ID(), run.DefinitionID())
+		if i%2 == 1 {
+			assert.Equal(t, Error, run.State())
+			assert.EqualError(t, run.Error(), "odd")
+		} else {
+			assert.Equal(t, Success, run.State())
+			assert.NoError(t, run.Error())
+		}
+	}
+	// 定义不保存运行的状态，错误也不会在运行之间累积
+	assert.Equal(t, Ready, task.State())
+	assert.NoError(t, pipeline.Error())
+	run, err := Run(context.Background(), task, 0)
+	require.NoError(t, err)
+	assert.Equal(t, Success, run.State())
+	assert.NotEqual(t, task.ID(), run.ID())
+}
@@ -0,0 +1,281 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Waiting is set on a task blocked until a signal of its execution arrives
+	Waiting State = "waiting"
+)
+
+var (
+	// ErrSuspended is returned by an execution that saved its position while waiting
+	// for a signal, it continues when executed again after the signal arrived
+	ErrSuspended   = errors.New("execution suspended")
+	ErrNoExecution = errors.New("task is not running in a registry execution")
+)
+
+// PendingSignal is a signal a task is waiting for, such as a manual approval
+type PendingSignal struct {
+	ExecutionID string    `json:"execution_id"`
+	Name        string    `json:"name"`
+	TaskID      string    `json:"task_id"`
+	TaskName    string    `json:"task_name"`
+	Since       time.Time `json:"since"`
+	Deadline    time.Time `json:"deadline"` // 零值表示不超时
+}
+
+type signalPayloadKey struct {
+	name string
+}
+
+// SignalPayload returns the payload of the signal name received before the task ran
+func SignalPayload(ctx context.Context, name string) json.RawMessage {
+	v, _ := ctx.Value(signalPayloadKey{name}).(json.RawMessage)
+	return v
+}
+
+type signalOptions struct {
+	timeout        time.Duration
+	defaultPayload interface{}
+}
+
+type SignalOption interface {
+	apply(*signalOptions)
+}
+
+type timeoutSignalOption struct {
+	timeout        time.Duration
+	defaultPayload interface{}
+}
+
+func (t timeoutSignalOption) apply(opts *signalOptions) {
+	opts.timeout = t.timeout
+	opts.defaultPayload = t.defaultPayload
+}
+
+// WithSignalTimeout stop waiting after timeout and run the task with defaultPayload
+func WithSignalTimeout(timeout time.Duration, defaultPayload interface{}) SignalOption {
+	return timeoutSignalOption{timeout: timeout, defaultPayload: defaultPayload}
+}
+
+// signalMetaKey is the key of the metadata holding the signal a task waits for
+const signalMetaKey = "signal"
+
+// SignalTask runs t once the signal name of its execution arrived through registry.Signal,
+// t reads the payload with SignalPayload. While waiting the task is in Waiting state,
+// the key "signal" of its metadata holds the PendingSignal. When the registry persists checkpoints the
+// execution is suspended instead of blocking a goroutine, the registry executes it again
+// once the signal arrived or the timeout passed.
+func SignalTask(t Task, name string, opts ...SignalOption) Task {
+	opt := &signalOptions{}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	return &signalTask{
+		Task:           t,
+		name:           name,
+		timeout:        opt.timeout,
+		defaultPayload: opt.defaultPayload,
+	}
+}
+
+type signalTask struct {
+	Task
+	name           string
+	timeout        time.Duration
+	defaultPayload interface{}
+}
+
+func (s *signalTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	e, ok := ctx.Value(executionKey{}).(*execution)
+	if !ok {
+		return ErrNoExecution
+	}
+	ctx, info := startRun(ctx, s)
+	payload, err := s.wait(ctx, e, info)
+	if err == nil {
+		m := MetaOf(info)
+		delete(m, signalMetaKey)
+		_ = m.Save(info)
+		err = s.Task.Execute(context.WithValue(ctx, signalPayloadKey{s.name}, payload), input, callbacks...)
+	}
+	if !errors.Is(err, ErrSuspended) {
+		info.AddError(err)
+		markCancelled(ctx, info)
+	}
+	return err
+}
+
+func (s *signalTask) wait(ctx context.Context, e *execution, info Info) (json.RawMessage, error) {
+	e.mutex.Lock()
+	if payload, ok := e.signals[s.name]; ok {
+		delete(e.signals, s.name)
+		delete(e.pending, s.name)
+		e.mutex.Unlock()
+		return payload, nil
+	}
+	pending, ok := e.pending[s.name]
+	if !ok {
+		pending = PendingSignal{
+			ExecutionID: e.id,
+			Name:        s.name,
+			TaskID:      s.Task.ID(),
+			TaskName:    s.Task.Name(),
+			Since:       time.Now(),
+		}
+		if s.timeout > 0 {
+			pending.Deadline = pending.Since.Add(s.timeout)
+		}
+		e.pending[s.name] = pending
+	}
+	if !pending.Deadline.IsZero() && !time.Now().Before(pending.Deadline) {
+		delete(e.pending, s.name)
+		e.mutex.Unlock()
+		return json.Marshal(s.defaultPayload)
+	}
+	if e.store != nil {
+		// 保存检查点后退出，信号到达或超时后由注册表再次执行
+		e.suspended = true
+		if !pending.Deadline.IsZero() && (e.wake.IsZero() || pending.Deadline.Before(e.wake)) {
+			e.wake = pending.Deadline
+		}
+		e.mutex.Unlock()
+		s.waiting(info, pending)
+		if err := e.save(ctx); err != nil {
+			return nil, err
+		}
+		return nil, ErrSuspended
+	}
+	ch := make(chan json.RawMessage, 1)
+	e.waiters[s.name] = ch
+	e.mutex.Unlock()
+	defer func() {
+		e.mutex.Lock()
+		delete(e.waiters, s.name)
+		delete(e.pending, s.name)
+		e.mutex.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if !pending.Deadline.IsZero() {
+		timer := time.NewTimer(time.Until(pending.Deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	s.waiting(info, pending)
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case payload := <-ch:
+		info.SetState(Running)
+		return payload, nil
+	case <-timeout:
+		info.SetState(Running)
+		return json.Marshal(s.defaultPayload)
+	}
+}
+
+func (s *signalTask) waiting(info Info, pending PendingSignal) {
+	info.SetState(Waiting)
+	_ = SetMeta(info, signalMetaKey, pending)
+}
+
+// Signal delivers a signal with payload to the execution id, a running execution receives it
+// immediately. A suspended execution is executed again in the background when the registry
+// suspended it, otherwise when it is executed again.
+func (r *registry) Signal(ctx context.Context, id, name string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	if e, ok := r.executions[id]; ok {
+		// 持有注册表的锁投递，结束时保存的检查点不会遗漏该信号
+		e.mutex.Lock()
+		if ch, waiting := e.waiters[name]; waiting {
+			delete(e.waiters, name)
+			ch <- data
+		} else {
+			e.signals[name] = data
+		}
+		e.mutex.Unlock()
+		r.mutex.Unlock()
+		return nil
+	}
+	if err = r.deliver(ctx, id, name, data); err != nil {
+		r.mutex.Unlock()
+		return err
+	}
+	_, suspended := r.suspended[id]
+	r.mutex.Unlock()
+	if suspended {
+		go r.wake(id)
+	}
+	return nil
+}
+
+// deliver saves the signal in the checkpoint of the execution id which is not running,
+// it is called with the registry locked so that an execution starting meanwhile loads it
+func (r *registry) deliver(ctx context.Context, id, name string, data json.RawMessage) error {
+	if r.store == nil {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	cp, ok, err := r.store.Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	if cp.Signals == nil {
+		cp.Signals = make(map[string]json.RawMessage)
+	}
+	cp.Signals[name] = data
+	pending := cp.Pending[:0]
+	for _, p := range cp.Pending {
+		if p.Name != name {
+			pending = append(pending, p)
+		}
+	}
+	cp.Pending = pending
+	cp.UpdateTime = time.Now()
+	return r.store.Save(ctx, cp)
+}
+
+// Pending lists the signals the running and suspended executions are waiting for
+func (r *registry) Pending(ctx context.Context) ([]PendingSignal, error) {
+	list := make([]PendingSignal, 0)
+	running := make(map[string]bool)
+	r.mutex.Lock()
+	for id, e := range r.executions {
+		running[id] = true
+		e.mutex.Lock()
+		for name, p := range e.pending {
+			if _, waiting := e.waiters[name]; waiting {
+				list = append(list, p)
+			}
+		}
+		e.mutex.Unlock()
+	}
+	r.mutex.Unlock()
+	if r.store == nil {
+		return list, nil
+	}
+	checkpoints, err := r.store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, cp := range checkpoints {
+		if !running[cp.ExecutionID] {
+			list = append(list, cp.Pending...)
+		}
+	}
+	return list, nil
+}
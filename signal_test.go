@@ -0,0 +1,186 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func approval(ctx context.Context, _ interface{}) error {
+	if string(SignalPayload(ctx, "approve")) != "true" {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func TestSignalTask(t *testing.T) {
+	r := NewRegistry()
+	hub := NewEventHub(0)
+	var run Info
+	task := SignalTask(NewFunc(approval, WithEventHub(hub)), "approve")
+	sub := hub.Subscribe(task.ID(), 0)
+	defer sub.Close()
+	done := make(chan error)
+	go func() { done <- r.Execute(context.Background(), "exec-1", task, nil, recordRun(&run)) }()
+	awaitState(t, sub, Waiting)
+
+	var pending []PendingSignal
+	assert.Eventually(t, func() bool {
+		var err error
+		pending, err = r.Pending(context.Background())
+		return err == nil && len(pending) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "exec-1", pending[0].ExecutionID)
+	assert.Equal(t, "approve", pending[0].Name)
+	assert.Equal(t, task.ID(), pending[0].TaskID)
+
+	require.NoError(t, r.Signal(context.Background(), "exec-1", "approve", true))
+	assert.NoError(t, <-done)
+	assert.Equal(t, Success, run.State())
+
+	// 超时后使用默认值
+	task = SignalTask(NewFunc(approval), "approve", WithSignalTimeout(10*time.Millisecond, false))
+	assert.EqualError(t, r.Execute(context.Background(), "exec-2", task, nil), "rejected")
+	assert.ErrorIs(t, task.Execute(context.Background(), nil), ErrNoExecution)
+}
+
+func TestSignalTaskSuspended(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	var deployed int32
+	newPipeline := func() Task {
+		return NewTaskPipeline(WithInfo(DefaultTaskInfo("deploy"))).WithTasks(
+			NewFunc(UI),
+			SignalTask(NewFunc(approval), "approve"),
+			NewFunc(func(context.Context, interface{}) error {
+				atomic.AddInt32(&deployed, 1)
+				return nil
+			}),
+		)
+	}
+	r := NewRegistry(WithCheckpointStore(store))
+	var run Info
+	assert.ErrorIs(t, r.Execute(context.Background(), "exec-1", newPipeline(), nil, recordRun(&run)), ErrSuspended)
+	assert.Equal(t, Waiting, run.State())
+	assert.Empty(t, r.Running())
+
+	pending, err := r.Pending(context.Background())
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "approve", pending[0].Name)
+
+	// 信号到达后在后台继续执行
+	require.NoError(t, r.Signal(context.Background(), "exec-1", "approve", true))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&deployed) == 1 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, ok, err := store.Load(context.Background(), "exec-1")
+		return err == nil && !ok
+	}, time.Second, time.Millisecond)
+
+	// 重启后再次执行时继续
+	assert.ErrorIs(t, r.Execute(context.Background(), "exec-2", newPipeline(), nil), ErrSuspended)
+	r = NewRegistry(WithCheckpointStore(store))
+	require.NoError(t, r.Signal(context.Background(), "exec-2", "approve", true))
+	pending, err = r.Pending(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+	assert.NoError(t, r.Execute(context.Background(), "exec-2", newPipeline(), nil))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&deployed))
+}
+
+func TestSignalTaskSuspendedTimeout(t *testing.T) {
+	r := NewRegistry(WithCheckpointStore(NewMemoryCheckpointStore()))
+	task := SignalTask(NewFunc(approval), "approve", WithSignalTimeout(20*time.Millisecond, false))
+	done := make(chan error, 1)
+	callback := callbackFunc(func(_ context.Context, _ Info, _ interface{}, err error) {
+		if !errors.Is(err, ErrSuspended) {
+			done <- err
+		}
+	})
+	assert.ErrorIs(t, r.Execute(context.Background(), "exec-1", task, nil, callback), ErrSuspended)
+	select {
+	case err := <-done:
+		assert.EqualError(t, err, "rejected")
+	case <-time.After(time.Second):
+		t.Fatal("the timeout did not resume the execution")
+	}
+}
+
+func TestSignalTaskSuspendedTCC(t *testing.T) {
+	r := NewRegistry(WithCheckpointStore(NewMemoryCheckpointStore()))
+	var cancelled, confirmed int32
+	pipeline := NewTCCPipeline().WithTCCs(
+		NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(&cancelled, 1)
+			return nil
+		})),
+		NewTCC(SignalTask(NewFunc(approval), "approve"), NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(&confirmed, 1)
+			return nil
+		}), NewFunc(UI)),
+	)
+	assert.ErrorIs(t, r.ExecuteTCC(context.Background(), "exec-1", pipeline, nil), ErrSuspended)
+	assert.Zero(t, atomic.LoadInt32(&cancelled))
+
+	require.NoError(t, r.Signal(context.Background(), "exec-1", "approve", true))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&confirmed) == 1 }, time.Second, time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&cancelled))
+}
+
+func TestSignalTaskSuspendedCancel(t *testing.T) {
+	store := NewMemoryCheckpointStore()
+	r := NewRegistry(WithCheckpointStore(store))
+	var cancelled, approved int32
+	pipeline := NewTCCPipeline().WithTCCs(
+		NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(&cancelled, 1)
+			return nil
+		})),
+		NewTCC(SignalTask(NewFunc(func(context.Context, interface{}) error {
+			atomic.AddInt32(&approved, 1)
+			return nil
+		}), "approve", WithSignalTimeout(50*time.Millisecond, true)), NewFunc(UI), NewFunc(UI)),
+	)
+	assert.ErrorIs(t, r.ExecuteTCC(context.Background(), "exec-1", pipeline, nil), ErrSuspended)
+
+	// 挂起的执行被取消后回滚，信号超时也不再唤醒它
+	require.NoError(t, r.Cancel("exec-1"))
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&cancelled) == 1 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, ok, err := store.Load(context.Background(), "exec-1")
+		return err == nil && !ok
+	}, time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.Zero(t, atomic.LoadInt32(&approved))
+	assert.ErrorIs(t, r.Cancel("exec-1"), ErrExecutionNotFound)
+}
+
+func TestSignalTaskMetadata(t *testing.T) {
+	r := NewRegistry()
+	hub := NewEventHub(0)
+	task := SignalTask(NewFunc(approval, WithEventHub(hub), WithMeta("owner", "ops")), "approve")
+	sub := hub.Subscribe(task.ID(), 0)
+	defer sub.Close()
+	var run Info
+	ctx := context.WithValue(context.Background(), runHookKey{}, &run)
+	done := make(chan error)
+	go func() { done <- r.Execute(ctx, "exec-1", task, nil) }()
+	awaitState(t, sub, Waiting)
+
+	// 等待的信号与自定义的元数据合并
+	var pending PendingSignal
+	meta := MetaOf(run)
+	assert.Equal(t, "ops", meta.String("owner"))
+	ok, err := meta.Get("signal", &pending)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "approve", pending.Name)
+
+	require.NoError(t, r.Signal(context.Background(), "exec-1", "approve", true))
+	assert.NoError(t, <-done)
+	assert.JSONEq(t, `{"owner":"ops"}`, string(run.Metadata()))
+}
@@ -0,0 +1,308 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+// SnapshotVersion is the version of the snapshot schema written by this library. Fields are only added
+// within a version, so a snapshot of an older version stays readable.
+const SnapshotVersion = 1
+
+// snapshotMagic starts the binary encoding of a snapshot, it is followed by the version
+const snapshotMagic = 'W'
+
+var (
+	ErrSnapshotVersion = errors.New("unsupported snapshot version")
+	ErrReadOnly        = errors.New("info is a read-only snapshot")
+)
+
+// InfoSnapshot is the serialized state of an info and of the infos it runs.
+// Metadata holds json metadata as is, RawMetadata any other metadata.
+type InfoSnapshot struct {
+	ID           string            `json:"id"`
+	DefinitionID string            `json:"definition_id,omitempty"`
+	ParentID     string            `json:"parent_id,omitempty"`
+	RootID       string            `json:"root_id"`
+	ExecutionID  string            `json:"execution_id,omitempty"`
+	Name         string            `json:"name"`
+	Trigger      string            `json:"trigger,omitempty"`
+	State        State             `json:"state"`
+	Description  string            `json:"description,omitempty"`
+	CreateTime   time.Time         `json:"create_time"`
+	UpdateTime   time.Time         `json:"update_time"`
+	Metadata     json.RawMessage   `json:"metadata,omitempty"`
+	RawMetadata  []byte            `json:"raw_metadata,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Errors       []string          `json:"errors,omitempty"`
+	Transitions  []StateTransition `json:"transitions,omitempty"`
+	Children     []*InfoSnapshot   `json:"children,omitempty"`
+}
+
+// Snapshot is a versioned InfoSnapshot of an info tree, taken at Time
+type Snapshot struct {
+	Version int           `json:"version"`
+	Time    time.Time     `json:"time"`
+	Root    *InfoSnapshot `json:"root"`
+}
+
+// composite is implemented by the tasks and TCCs that run other infos
+type composite interface {
+	children() []Info
+}
+
+func childrenOf(info Info) []Info {
+	if c, ok := info.(composite); ok {
+		return c.children()
+	}
+	return nil
+}
+
+// runRecorder is implemented by the infos keeping the infos their runs start
+type runRecorder interface {
+	addChild(Info)
+}
+
+// addChild records child as started by parent when parent is a run, so that a run is the composite
+// of its child runs
+func addChild(parent, child Info) {
+	if parent.DefinitionID() == "" {
+		return
+	}
+	if r, ok := parent.(runRecorder); ok {
+		r.addChild(child)
+	}
+}
+
+// NewSnapshot takes a snapshot of root and of the infos it runs. The infos, such as runs or the infos
+// of Rebuild, are nested under the info of their ParentID. A definition shows the structure of a task,
+// a run (see Run) the child runs it started.
+func NewSnapshot(root Info, infos ...Info) *Snapshot {
+	byParent := make(map[string][]Info)
+	for _, info := range infos {
+		if parentID := info.ParentID(); parentID != "" {
+			byParent[parentID] = append(byParent[parentID], info)
+		}
+	}
+	return &Snapshot{
+		Version: SnapshotVersion,
+		Time:    time.Now(),
+		Root:    snapshotInfo(root, byParent, make(map[string]bool)),
+	}
+}
+
+func snapshotInfo(info Info, byParent map[string][]Info, visited map[string]bool) *InfoSnapshot {
+	visited[info.ID()] = true
+	s := &InfoSnapshot{
+		ID:           info.ID(),
+		DefinitionID: info.DefinitionID(),
+		ParentID:     info.ParentID(),
+		RootID:       info.RootID(),
+		ExecutionID:  info.ExecutionID(),
+		Name:         info.Name(),
+		Trigger:      info.Trigger(),
+		State:        info.State(),
+		Description:  info.Description(),
+		CreateTime:   info.CreateTime(),
+		UpdateTime:   info.UpdateTime(),
+		Transitions:  info.Transitions(),
+	}
+	if meta := info.Metadata(); len(meta) > 0 {
+		if json.Valid(meta) {
+			s.Metadata = append(json.RawMessage(nil), meta...)
+		} else {
+			s.RawMetadata = append([]byte(nil), meta...)
+		}
+	}
+	if labels := info.Labels(); len(labels) > 0 {
+		s.Labels = labels
+	}
+	for _, err := range multierr.Errors(info.Error()) {
+		s.Errors = append(s.Errors, err.Error())
+	}
+	for _, child := range append(childrenOf(info), byParent[info.ID()]...) {
+		// 同一个定义可能被多次引用
+		if child == nil || visited[child.ID()] {
+			continue
+		}
+		s.Children = append(s.Children, snapshotInfo(child, byParent, visited))
+	}
+	return s
+}
+
+// DecodeSnapshot decodes a snapshot from its json or binary encoding
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	s := &Snapshot{}
+	if len(data) > 0 && data[0] == snapshotMagic {
+		return s, s.UnmarshalBinary(data)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, s.check()
+}
+
+func (s *Snapshot) check() error {
+	if s.Version < 1 || s.Version > SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, s.Version)
+	}
+	if s.Root == nil {
+		return errors.New("snapshot has no root")
+	}
+	return nil
+}
+
+// snapshotData has the fields of Snapshot without its encoding methods, which gob would call again
+type snapshotData Snapshot
+
+// MarshalBinary encodes the snapshot as its version followed by its gob encoding
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(snapshotMagic)
+	buf.WriteByte(byte(s.Version))
+	if err := gob.NewEncoder(&buf).Encode((*snapshotData)(s)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	if len(data) < 2 || data[0] != snapshotMagic {
+		return errors.New("not a binary snapshot")
+	}
+	if version := int(data[1]); version < 1 || version > SnapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+	if err := gob.NewDecoder(bytes.NewReader(data[2:])).Decode((*snapshotData)(s)); err != nil {
+		return err
+	}
+	return s.check()
+}
+
+// Info returns a read-only Info of the snapshot, its setters are ignored
+func (s *InfoSnapshot) Info() Info {
+	return &readOnlyInfo{snapshot: s}
+}
+
+type readOnlyInfo struct {
+	snapshot *InfoSnapshot
+}
+
+func (r *readOnlyInfo) ID() string                     { return r.snapshot.ID }
+func (r *readOnlyInfo) DefinitionID() string           { return r.snapshot.DefinitionID }
+func (r *readOnlyInfo) ParentID() string               { return r.snapshot.ParentID }
+func (r *readOnlyInfo) RootID() string                 { return r.snapshot.RootID }
+func (r *readOnlyInfo) ExecutionID() string            { return r.snapshot.ExecutionID }
+func (r *readOnlyInfo) Name() string                   { return r.snapshot.Name }
+func (r *readOnlyInfo) Trigger() string                { return r.snapshot.Trigger }
+func (r *readOnlyInfo) State() State                   { return r.snapshot.State }
+func (r *readOnlyInfo) Description() string            { return r.snapshot.Description }
+func (r *readOnlyInfo) CreateTime() time.Time          { return r.snapshot.CreateTime }
+func (r *readOnlyInfo) UpdateTime() time.Time          { return r.snapshot.UpdateTime }
+func (r *readOnlyInfo) SetParent(Info)                 {}
+func (r *readOnlyInfo) SetExecutionID(string)          {}
+func (r *readOnlyInfo) SetTrigger(string)              {}
+func (r *readOnlyInfo) SetName(string)                 {}
+func (r *readOnlyInfo) SetState(State)                 {}
+func (r *readOnlyInfo) SetDescription(string)          {}
+func (r *readOnlyInfo) SetMetadata([]byte)             {}
+func (r *readOnlyInfo) SetLabel(string, string)        {}
+func (r *readOnlyInfo) AddError(error, ...bool)        {}
+func (r *readOnlyInfo) Transition(State) error         { return ErrReadOnly }
+func (r *readOnlyInfo) Transitions() []StateTransition { return r.snapshot.Transitions }
+
+func (r *readOnlyInfo) Metadata() []byte {
+	if len(r.snapshot.Metadata) > 0 {
+		return r.snapshot.Metadata
+	}
+	return r.snapshot.RawMetadata
+}
+
+func (r *readOnlyInfo) Labels() map[string]string {
+	labels := make(map[string]string, len(r.snapshot.Labels))
+	for k, v := range r.snapshot.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+func (r *readOnlyInfo) Error() error {
+	var err error
+	for _, e := range r.snapshot.Errors {
+		err = multierr.Append(err, errors.New(e))
+	}
+	return err
+}
+
+// NewRun returns a writable info of a run of the snapshot
+func (r *readOnlyInfo) NewRun() Info {
+	run := DefaultTaskInfo("").(*defaultTaskInfo)
+	run.definitionID = r.ID()
+	run.SetName(r.Name())
+	run.SetTrigger(r.Trigger())
+	run.SetDescription(r.Description())
+	run.SetMetadata(r.Metadata())
+	for k, v := range r.snapshot.Labels {
+		run.SetLabel(k, v)
+	}
+	return run
+}
+
+func (r *readOnlyInfo) children() []Info {
+	children := make([]Info, 0, len(r.snapshot.Children))
+	for _, child := range r.snapshot.Children {
+		children = append(children, child.Info())
+	}
+	return children
+}
+
+// Snapshot rebuilds the info tree of the execution id from its history, see WithHistoryStore
+func (r *registry) Snapshot(ctx context.Context, id string) (*Snapshot, error) {
+	if r.history == nil {
+		return nil, fmt.Errorf("%w: %s has no history", ErrExecutionNotFound, id)
+	}
+	events, err := r.history.Load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	infos := Rebuild(events)
+	root, ok := infos[rootStep]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrExecutionNotFound, id)
+	}
+	steps := make([]string, 0, len(infos))
+	for step := range infos {
+		if step != rootStep {
+			steps = append(steps, step)
+		}
+	}
+	sort.Slice(steps, func(i, j int) bool { return stepLess(steps[i], steps[j]) })
+	descendants := make([]Info, 0, len(steps))
+	for _, step := range steps {
+		descendants = append(descendants, infos[step])
+	}
+	return NewSnapshot(root, descendants...), nil
+}
+
+// stepLess orders the steps of a history by position, "0/2" before "0/10"
+func stepLess(a, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			ai, _ := strconv.Atoi(as[i])
+			bi, _ := strconv.Atoi(bs[i])
+			return ai < bi
+		}
+	}
+	return len(as) < len(bs)
+}
@@ -0,0 +1,123 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	charge := NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(UI), WithLabels(map[string]string{"team": "payments"}))
+	notify := NewFunc(UI, WithMeta("channel", "email"))
+	notify.AddError(errors.New("smtp down"))
+	notify.AddError(errors.New("retry later"))
+	raw := NewFunc(UI)
+	raw.SetMetadata([]byte("raw"))
+	pipeline := NewTaskPipeline().WithTasks(NewTCCTask(charge).Strict(), notify, raw)
+
+	snapshot := NewSnapshot(pipeline)
+	assert.Equal(t, SnapshotVersion, snapshot.Version)
+	require.Len(t, snapshot.Root.Children, 3)
+	tcc := snapshot.Root.Children[0].Children[0]
+	assert.Equal(t, charge.ID(), tcc.ID)
+	assert.Len(t, tcc.Children, 3)
+	assert.Equal(t, map[string]string{"team": "payments"}, tcc.Labels)
+	assert.Equal(t, []string{"smtp down", "retry later"}, snapshot.Root.Children[1].Errors)
+	assert.JSONEq(t, `{"channel":"email"}`, string(snapshot.Root.Children[1].Metadata))
+	assert.Equal(t, []byte("raw"), snapshot.Root.Children[2].RawMetadata)
+
+	data, err := json.Marshal(snapshot)
+	require.NoError(t, err)
+	decoded, err := DecodeSnapshot(data)
+	require.NoError(t, err)
+	reencoded, err := json.Marshal(decoded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(reencoded))
+
+	binary, err := snapshot.MarshalBinary()
+	require.NoError(t, err)
+	decoded, err = DecodeSnapshot(binary)
+	require.NoError(t, err)
+	reencoded, err = json.Marshal(decoded)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(data), string(reencoded))
+
+	_, err = DecodeSnapshot([]byte(`{"version":2,"root":{"id":"a"}}`))
+	assert.ErrorIs(t, err, ErrSnapshotVersion)
+	binary[1] = 2
+	_, err = DecodeSnapshot(binary)
+	assert.ErrorIs(t, err, ErrSnapshotVersion)
+}
+
+func TestSnapshotRun(t *testing.T) {
+	charge := NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(UI))
+	notify := NewFunc(UI)
+	pipeline := NewTaskPipeline().WithTasks(NewTCCTask(charge).Strict(), notify)
+	run, err := Run(context.Background(), pipeline, nil)
+	require.NoError(t, err)
+
+	// 运行的子节点是其启动的子运行
+	snapshot := NewSnapshot(run)
+	assert.Equal(t, Success, snapshot.Root.State)
+	require.Len(t, snapshot.Root.Children, 2)
+	strict, sent := snapshot.Root.Children[0], snapshot.Root.Children[1]
+	assert.Equal(t, run.ID(), strict.ParentID)
+	assert.Equal(t, Success, strict.State)
+	assert.Equal(t, notify.ID(), sent.DefinitionID)
+	assert.Equal(t, Success, sent.State)
+	require.Len(t, strict.Children, 1)
+	assert.Equal(t, charge.ID(), strict.Children[0].ID)
+	assert.Equal(t, Success, strict.Children[0].State)
+}
+
+func TestSnapshotInfo(t *testing.T) {
+	task := NewFunc(UI, WithLabels(map[string]string{"tenant": "acme"}))
+	task.AddError(errors.New("boom"))
+	pipeline := NewTaskPipeline().WithTasks(task)
+	data, err := json.Marshal(NewSnapshot(pipeline))
+	require.NoError(t, err)
+	decoded, err := DecodeSnapshot(data)
+	require.NoError(t, err)
+
+	info := decoded.Root.Children[0].Info()
+	assert.Equal(t, task.ID(), info.ID())
+	assert.Equal(t, Error, info.State())
+	assert.EqualError(t, info.Error(), "boom")
+	assert.Equal(t, map[string]string{"tenant": "acme"}, info.Labels())
+	info.SetName("renamed")
+	assert.Equal(t, task.Name(), info.Name())
+	assert.ErrorIs(t, info.Transition(Running), ErrReadOnly)
+	assert.Equal(t, task.Transitions()[0].To, info.Transitions()[0].To)
+	assert.Equal(t, task.ID(), info.NewRun().DefinitionID())
+
+	// 只读的树可以再次生成快照
+	again, err := json.Marshal(NewSnapshot(decoded.Root.Info()).Root)
+	require.NoError(t, err)
+	root, err := json.Marshal(decoded.Root)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(root), string(again))
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	store := NewMemoryHistoryStore()
+	registry := NewRegistry(WithHistoryStore(store))
+	pipeline := countingPipeline(make([]int, 2), 1)
+	assert.Error(t, registry.Execute(context.Background(), "order-5", pipeline, nil))
+
+	snapshot, err := registry.Snapshot(context.Background(), "order-5")
+	require.NoError(t, err)
+	assert.Equal(t, pipeline.ID(), snapshot.Root.ID)
+	assert.Equal(t, Error, snapshot.Root.State)
+	require.Len(t, snapshot.Root.Children, 2)
+	assert.Equal(t, Success, snapshot.Root.Children[0].State)
+	assert.Equal(t, []string{"boom"}, snapshot.Root.Children[1].Errors)
+
+	_, err = registry.Snapshot(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrExecutionNotFound)
+	_, err = NewRegistry().Snapshot(context.Background(), "order-5")
+	assert.ErrorIs(t, err, ErrExecutionNotFound)
+}
@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NewSQLLocker returns a Locker keeping one lease row per key in table, which is expected as
+//
+//	CREATE TABLE <table> (
+//		name       VARCHAR(255) PRIMARY KEY,
+//		owner      VARCHAR(255) NOT NULL,
+//		expires_at BIGINT       NOT NULL -- unix nanoseconds
+//	)
+//
+// Statements use "?" placeholders unless dollar is true ("$1", as PostgreSQL expects).
+// Leases are compared with the local clock, so the clocks of the replicas must be in sync.
+func NewSQLLocker(db *sql.DB, table string, dollar bool) *sqlLocker {
+	return &sqlLocker{
+		db:     db,
+		table:  table,
+		dollar: dollar,
+	}
+}
+
+type sqlLocker struct {
+	db     *sql.DB
+	table  string
+	dollar bool
+	pruner pruner
+}
+
+func (s *sqlLocker) query(format string) string {
+	return sqlQuery(format, s.table, s.dollar)
+}
+
+// sqlQuery formats the statement for table, with "$1" placeholders when dollar is true
+func sqlQuery(format, table string, dollar bool) string {
+	query := fmt.Sprintf(format, table)
+	if !dollar {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&sb, "$%d", n)
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func (s *sqlLocker) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	deadline := now.Add(ttl).UnixNano()
+	if s.pruner.due(now) {
+		// 清理失败不影响抢占
+		_, _ = s.db.ExecContext(ctx, s.query("DELETE FROM %s WHERE expires_at < ?"), now.UnixNano())
+	}
+	// 续约自己的租约或抢占已过期的租约
+	result, err := s.db.ExecContext(ctx,
+		s.query("UPDATE %s SET owner = ?, expires_at = ? WHERE name = ? AND (owner = ? OR expires_at < ?)"),
+		owner, deadline, key, owner, now.UnixNano())
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n > 0 {
+		return err == nil, err
+	}
+	if _, err = s.db.ExecContext(ctx,
+		s.query("INSERT INTO %s (name, owner, expires_at) VALUES (?, ?, ?)"),
+		key, owner, deadline); err == nil {
+		return true, nil
+	}
+	// 插入冲突说明租约被其他副本持有
+	var holder string
+	if scanErr := s.db.QueryRowContext(ctx, s.query("SELECT owner FROM %s WHERE name = ?"), key).
+		Scan(&holder); scanErr == nil && holder != owner {
+		return false, nil
+	}
+	return false, err
+}
+
+func (s *sqlLocker) Release(ctx context.Context, key, owner string) error {
+	_, err := s.db.ExecContext(ctx, s.query("DELETE FROM %s WHERE name = ? AND owner = ?"), key, owner)
+	return err
+}
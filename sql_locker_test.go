@@ -0,0 +1,192 @@
+package workflow
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// anyArg matches any argument of an expected statement
+type anyArg struct{}
+
+// sqlStatement is a statement the scripted database expects, with its outcome
+type sqlStatement struct {
+	query    string
+	args     []interface{}
+	affected int64
+	rows     []driver.Value // 单列查询结果
+	err      error
+}
+
+// scriptedSQL is a database expecting exactly the given statements, in order
+type scriptedSQL struct {
+	t          *testing.T
+	mutex      sync.Mutex
+	statements []sqlStatement
+}
+
+// newScriptedSQL opens a database running the statements, they must all be run before the test ends
+func newScriptedSQL(t *testing.T, statements ...sqlStatement) *sql.DB {
+	s := &scriptedSQL{t: t, statements: statements}
+	db := sql.OpenDB(s)
+	t.Cleanup(func() {
+		_ = db.Close()
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		for _, st := range s.statements {
+			t.Errorf("scriptedsql: statement not run: %s %v", st.query, st.args)
+		}
+	})
+	return db
+}
+
+func (s *scriptedSQL) Connect(context.Context) (driver.Conn, error) {
+	return scriptedConn{s}, nil
+}
+
+func (s *scriptedSQL) Driver() driver.Driver {
+	return nil
+}
+
+// run checks the statement against the next expected one and returns its outcome
+func (s *scriptedSQL) run(query string, args []driver.Value) (sqlStatement, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.statements) == 0 {
+		s.t.Errorf("scriptedsql: unexpected statement: %s %v", query, args)
+		return sqlStatement{}, errors.New("scriptedsql: unexpected statement")
+	}
+	st := s.statements[0]
+	s.statements = s.statements[1:]
+	if !matchStatement(st, query, args) {
+		s.t.Errorf("scriptedsql: expected %s %v, got %s %v", st.query, st.args, query, args)
+		return sqlStatement{}, errors.New("scriptedsql: unexpected statement")
+	}
+	return st, st.err
+}
+
+func matchStatement(st sqlStatement, query string, args []driver.Value) bool {
+	if st.query != query || len(st.args) != len(args) {
+		return false
+	}
+	for i, arg := range st.args {
+		if _, ok := arg.(anyArg); !ok && !reflect.DeepEqual(arg, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+type scriptedConn struct {
+	db *scriptedSQL
+}
+
+func (c scriptedConn) Prepare(query string) (driver.Stmt, error) {
+	return scriptedStmt{db: c.db, query: query}, nil
+}
+
+func (c scriptedConn) Close() error {
+	return nil
+}
+
+func (c scriptedConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("scriptedsql: transactions are not supported")
+}
+
+type scriptedStmt struct {
+	db    *scriptedSQL
+	query string
+}
+
+func (s scriptedStmt) Close() error {
+	return nil
+}
+
+func (s scriptedStmt) NumInput() int {
+	return -1
+}
+
+func (s scriptedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	st, err := s.db.run(s.query, args)
+	return driver.RowsAffected(st.affected), err
+}
+
+func (s scriptedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	st, err := s.db.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptedRows{values: st.rows}, nil
+}
+
+type scriptedRows struct {
+	values []driver.Value
+}
+
+func (r *scriptedRows) Columns() []string {
+	return []string{"value"}
+}
+
+func (r *scriptedRows) Close() error {
+	return nil
+}
+
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+var errDuplicateKey = errors.New("duplicate key")
+
+func TestSQLLocker(t *testing.T) {
+	for _, dollar := range []bool{false, true} {
+		t.Run(fmt.Sprint(dollar), func(t *testing.T) {
+			q := func(format string) string { return sqlQuery(format, "leases", dollar) }
+			update := q("UPDATE %s SET owner = ?, expires_at = ? WHERE name = ? AND (owner = ? OR expires_at < ?)")
+			insert := q("INSERT INTO %s (name, owner, expires_at) VALUES (?, ?, ?)")
+			db := newScriptedSQL(t,
+				// 首次抢占时清理过期的租约
+				sqlStatement{query: q("DELETE FROM %s WHERE expires_at < ?"), args: []interface{}{anyArg{}}},
+				sqlStatement{query: update, args: []interface{}{"a", anyArg{}, "cron", "a", anyArg{}}},
+				sqlStatement{query: insert, args: []interface{}{"cron", "a", anyArg{}}, affected: 1},
+				// 续约
+				sqlStatement{query: update, args: []interface{}{"a", anyArg{}, "cron", "a", anyArg{}}, affected: 1},
+				// 租约被其他副本持有
+				sqlStatement{query: update, args: []interface{}{"b", anyArg{}, "cron", "b", anyArg{}}},
+				sqlStatement{query: insert, args: []interface{}{"cron", "b", anyArg{}}, err: errDuplicateKey},
+				sqlStatement{query: q("SELECT owner FROM %s WHERE name = ?"), args: []interface{}{"cron"}, rows: []driver.Value{"a"}},
+				// 插入失败且租约不存在时返回插入的错误
+				sqlStatement{query: update, args: []interface{}{"b", anyArg{}, "cron", "b", anyArg{}}},
+				sqlStatement{query: insert, args: []interface{}{"cron", "b", anyArg{}}, err: errDuplicateKey},
+				sqlStatement{query: q("SELECT owner FROM %s WHERE name = ?"), args: []interface{}{"cron"}},
+				sqlStatement{query: q("DELETE FROM %s WHERE name = ? AND owner = ?"), args: []interface{}{"cron", "a"}},
+			)
+			l := NewSQLLocker(db, "leases", dollar)
+			ctx := context.Background()
+			for _, owner := range []string{"a", "a"} {
+				ok, err := l.Acquire(ctx, "cron", owner, time.Second)
+				require.NoError(t, err)
+				assert.True(t, ok)
+			}
+			ok, err := l.Acquire(ctx, "cron", "b", time.Second)
+			require.NoError(t, err)
+			assert.False(t, ok)
+			_, err = l.Acquire(ctx, "cron", "b", time.Second)
+			assert.ErrorIs(t, err, errDuplicateKey)
+			require.NoError(t, l.Release(ctx, "cron", "a"))
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const sseKeepAlive = 15 * time.Second
+
+// SSEHandler streams the events of hub as server-sent events.
+// The "id" query parameter restricts the stream to one info, root or execution id, and the
+// Last-Event-ID header (or "last_event_id" query parameter) resumes the stream
+// after the given event.
+func SSEHandler(hub *EventHub) http.Handler {
+	return &sseHandler{hub: hub}
+}
+
+type sseHandler struct {
+	hub *EventHub
+}
+
+func (s *sseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var lastEventID uint64
+	if lastID != "" {
+		var err error
+		if lastEventID, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid last event id %q", lastID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub := s.hub.Subscribe(r.URL.Query().Get("id"), lastEventID)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case e, ok := <-sub.Events():
+			if !ok {
+				// 订阅因消费过慢被关闭，客户端会携带 Last-Event-ID 重连
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				return
+			}
+			if _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrIllegalTransition is returned by Info.Transition when the state machine forbids the move
+var ErrIllegalTransition = errors.New("illegal state transition")
+
+// maxTransitions bounds the transition history kept by an info
+const maxTransitions = 64
+
+// StateTransition is a move of an info from a state to another
+type StateTransition struct {
+	From State     `json:"from"`
+	To   State     `json:"to"`
+	Time time.Time `json:"time"`
+}
+
+// DefaultStateMachine validates the transitions of the infos created by DefaultTaskInfo
+// A TCC may be tried again after any outcome, and confirmed or cancelled again after it succeeded or failed.
+var DefaultStateMachine = NewStateMachine(map[State][]State{
+	Pending:   {Scheduled, Ready, Running, Skipped, Cancelled},
+	Scheduled: {Pending, Ready, Running, Skipped, Cancelled, TimedOut},
+	Ready: {Pending, Scheduled, Running, Trying, Confirming, Cancelling, Waiting, Paused, Success, Error, Skipped,
+		Cancelled, TimedOut},
+	Running:     {Retrying, Waiting, Paused, Success, Error, Skipped, Cancelled, TimedOut},
+	Retrying:    {Running, Success, Error, Cancelled, TimedOut},
+	Waiting:     {Running, Trying, Paused, Success, Error, Cancelled, TimedOut},
+	Paused:      {Running, Trying, Waiting, Success, Error, Cancelled},
+	Trying:      {Confirming, Cancelling, Waiting, Paused, Success, Error, Cancelled, TimedOut},
+	Confirming:  {Success, Error, Cancelled, TimedOut},
+	Cancelling:  {Compensated, Success, Error, Cancelled, TimedOut},
+	Success:     {Trying, Confirming, Cancelling, Compensated},
+	Error:       {Trying, Confirming, Retrying, Cancelling, Compensated, Cancelled, TimedOut},
+	TimedOut:    {Trying, Retrying, Cancelling, Cancelled},
+	Cancelled:   {Scheduled, Ready, Trying, Cancelling, Error},
+	Skipped:     nil,
+	Compensated: {Trying},
+})
+
+// StateMachine holds the legal transitions between states, moving to the same state is always legal
+type StateMachine struct {
+	mutex       sync.RWMutex
+	transitions map[State]map[State]bool
+	hooks       []func(info Info, from, to State)
+}
+
+// NewStateMachine returns a state machine allowing the moves from every key of transitions to its states
+func NewStateMachine(transitions map[State][]State) *StateMachine {
+	m := &StateMachine{
+		transitions: make(map[State]map[State]bool, len(transitions)),
+	}
+	for from, to := range transitions {
+		m.Allow(from, to...)
+	}
+	return m
+}
+
+// Allow adds the moves from from to every state of to
+func (m *StateMachine) Allow(from State, to ...State) *StateMachine {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.transitions[from] == nil {
+		m.transitions[from] = make(map[State]bool, len(to))
+	}
+	for _, state := range to {
+		m.transitions[from][state] = true
+	}
+	return m
+}
+
+// OnIllegal calls hook when SetState makes an illegal move, the move is applied anyway
+func (m *StateMachine) OnIllegal(hook func(info Info, from, to State)) *StateMachine {
+	m.mutex.Lock()
+	m.hooks = append(m.hooks, hook)
+	m.mutex.Unlock()
+	return m
+}
+
+// Can reports whether moving from from to to is legal, any move from the empty initial state is
+func (m *StateMachine) Can(from, to State) bool {
+	if from == "" || from == to {
+		return true
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.transitions[from][to]
+}
+
+// Validate returns ErrIllegalTransition when moving from from to to is illegal
+func (m *StateMachine) Validate(from, to State) error {
+	if !m.Can(from, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+	}
+	return nil
+}
+
+func (m *StateMachine) illegal(info Info, from, to State) {
+	m.mutex.RLock()
+	hooks := m.hooks
+	m.mutex.RUnlock()
+	for _, hook := range hooks {
+		hook(info, from, to)
+	}
+}
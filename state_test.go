@@ -0,0 +1,127 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransition(t *testing.T) {
+	now := time.Unix(0, 0)
+	info := DefaultTaskInfo("", func() time.Time { return now })
+	var illegal []State
+	DefaultStateMachine.OnIllegal(func(i Info, from, to State) {
+		if i.ID() == info.ID() {
+			illegal = append(illegal, from, to)
+		}
+	})
+
+	now = now.Add(time.Second)
+	require.NoError(t, info.Transition(Running))
+	assert.ErrorIs(t, info.Transition(Pending), ErrIllegalTransition)
+	assert.Equal(t, Running, info.State())
+	require.NoError(t, info.Transition(Running))
+
+	// SetState 不校验，只触发钩子
+	info.SetState(Success)
+	info.SetState(Running)
+	assert.Equal(t, []State{Success, Running}, illegal)
+	assert.Equal(t, []StateTransition{
+		{From: "", To: Ready, Time: time.Unix(0, 0)},
+		{From: Ready, To: Running, Time: now},
+		{From: Running, To: Success, Time: now},
+		{From: Success, To: Running, Time: now},
+	}, info.Transitions())
+
+	m := NewStateMachine(map[State][]State{Ready: {Running}}).Allow(Running, Success)
+	assert.NoError(t, m.Validate(Ready, Running))
+	assert.NoError(t, m.Validate(Running, Running))
+	assert.ErrorIs(t, m.Validate(Ready, Success), ErrIllegalTransition)
+}
+
+func TestRetryingState(t *testing.T) {
+	var calls int32
+	run, err := Run(context.Background(), RetryTask(failingTask(1, &calls), WithInterval(time.Millisecond)), nil)
+	require.NoError(t, err)
+	var states []State
+	for _, transition := range run.Transitions() {
+		states = append(states, transition.To)
+	}
+	assert.Equal(t, []State{Ready, Running, Retrying, Success}, states)
+
+	var tries int32
+	tcc := NewTCC(failingTask(0, &tries), NewFunc(UI), NewFunc(UI))
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	assert.Equal(t, Trying, tcc.State())
+	require.NoError(t, tcc.Confirm(context.Background(), nil))
+	assert.Equal(t, []StateTransition{}, illegalTransitions(tcc))
+}
+
+// illegalTransitions returns the transitions of info the default state machine forbids
+func illegalTransitions(info Info) []StateTransition {
+	illegal := []StateTransition{}
+	for _, transition := range info.Transitions() {
+		if !DefaultStateMachine.Can(transition.From, transition.To) {
+			illegal = append(illegal, transition)
+		}
+	}
+	return illegal
+}
+
+func TestOutcomeStates(t *testing.T) {
+	// TCC 可以重复执行，撤销已确认的 TCC 为 Compensated
+	tcc := NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(UI))
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	require.NoError(t, tcc.Confirm(context.Background(), nil))
+	require.NoError(t, tcc.Confirm(context.Background(), nil))
+	require.NoError(t, tcc.Cancel(context.Background(), nil))
+	assert.Equal(t, Compensated, tcc.State())
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	require.NoError(t, tcc.Cancel(context.Background(), nil))
+	assert.Equal(t, Cancelled, tcc.State())
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	assert.Equal(t, []StateTransition{}, illegalTransitions(tcc))
+
+	choice := NewChoiceTask().When("never", func(context.Context, interface{}) bool { return false }, NewFunc(UI)).
+		WithDefault(nil)
+	run, err := Run(context.Background(), choice, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Skipped, run.State())
+
+	slow := NewTCC(NewFunc(func(ctx context.Context, _ interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), NewFunc(UI), NewFunc(UI))
+	group := NewTCCGroup().WithTimeout(10*time.Millisecond, PhaseTry).WithTCCs(slow)
+	assert.ErrorIs(t, group.Try(context.Background(), nil), context.DeadlineExceeded)
+	assert.Equal(t, TimedOut, group.State())
+	// 回滚后保留 Try 的错误
+	assert.ErrorIs(t, group.Cancel(context.Background(), nil), context.DeadlineExceeded)
+	assert.Equal(t, Cancelled, group.State())
+	assert.Equal(t, []StateTransition{}, illegalTransitions(group))
+}
+
+func TestTimerStates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tw := NewTimeWheel(10*time.Millisecond, 10)
+	tw.Start(ctx)
+
+	fired, removed := NewFunc(UI), NewFunc(UI)
+	tw.AddTimer(DelayTask{Delay: 30 * time.Millisecond, Task: fired})
+	tw.AddTimer(DelayTask{Delay: time.Second, Task: removed})
+	assert.Eventually(t, func() bool { return removed.State() == Scheduled }, time.Second, time.Millisecond)
+	tw.RemoveTimer(removed.ID())
+	assert.Eventually(t, func() bool { return removed.State() == Cancelled }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return fired.State() == Ready }, time.Second, time.Millisecond)
+	var states []State
+	for _, transition := range fired.Transitions() {
+		states = append(states, transition.To)
+	}
+	assert.Equal(t, []State{Ready, Scheduled, Ready}, states)
+	assert.Equal(t, []StateTransition{}, illegalTransitions(fired))
+	assert.Equal(t, []StateTransition{}, illegalTransitions(removed))
+}
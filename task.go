@@ -2,15 +2,22 @@ package workflow
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"runtime"
+	"sync/atomic"
 
 	"go.uber.org/multierr"
 )
 
-// Task is library's minimum unit
+// ErrTCCRunning is returned by a task of a TCC which is already executing
+var ErrTCCRunning = errors.New("tcc is already running")
+
+// Task is library's minimum unit. A task is a definition, every Execute creates
+// a new run with its own Info, see Run
 type Task interface {
+	Info
 	Execute(ctx context.Context, input interface{}, callbacks ...Callback) error
 }
 
@@ -21,6 +28,10 @@ func NewFunc(f func(context.Context, interface{}) error, opts ...Option) Task {
 	for _, o := range opts {
 		o.apply(opt)
 	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
 	// 初始化状态
 	if opt.info.Name() == "" {
 		funcName := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
@@ -41,7 +52,9 @@ type funcTask struct {
 }
 
 func (f *funcTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) (err error) {
-	f.SetState(Running)
+	ctx, info := startRun(ctx, f)
+	info.SetState(Running)
+	ctx, run, replayed, err := beginRun(ctx, f)
 	panicked := true
 	defer func() {
 		if panicked {
@@ -52,20 +65,27 @@ func (f *funcTask) Execute(ctx context.Context, input interface{}, callbacks ...
 				err = multierr.Append(err, fmt.Errorf("[Recover] found:%v,trace:\n%s", r, buf))
 			}
 		}
-		f.AddError(err)
+		err = multierr.Append(err, run.end(ctx, err))
+		info.AddError(err)
+		markCancelled(ctx, info)
 
 		for _, callback := range f.callbacks {
-			callback.Trigger(ctx, f.Info, input, err)
+			callback.Trigger(ctx, info, input, err)
 		}
 		for _, callback := range callbacks {
-			callback.Trigger(ctx, f.Info, input, err)
+			callback.Trigger(ctx, info, input, err)
 		}
 	}()
-	err = f.f(ctx, input)
+	if !replayed && err == nil {
+		err = f.f(ctx, input)
+	}
 	panicked = false
 	return
 }
 
+// NewTCCTask returns a task running the phases of t. A TCC keeps the state of its run,
+// so the task executes once at a time, a concurrent Execute fails with ErrTCCRunning.
+// Build a TCC per run to execute them concurrently, as the map task does.
 func NewTCCTask(t TCC, opts ...Option) *simpleTCCTask {
 	opt := &options{
 		info: DefaultTaskInfo(""),
@@ -73,6 +93,10 @@ func NewTCCTask(t TCC, opts ...Option) *simpleTCCTask {
 	for _, o := range opts {
 		o.apply(opt)
 	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
 	// 初始化状态
 	opt.info.SetName(fmt.Sprintf("%s-task", t.Name()))
 	opt.info.SetState(Ready)
@@ -88,6 +112,23 @@ type simpleTCCTask struct {
 	Info
 	tcc       TCC
 	callbacks []Callback
+	running   int32
+}
+
+// acquire reserves the TCC for a run, release gives it back
+func (t *simpleTCCTask) acquire() error {
+	if !atomic.CompareAndSwapInt32(&t.running, 0, 1) {
+		return fmt.Errorf("%w: %s", ErrTCCRunning, t.tcc.Name())
+	}
+	return nil
+}
+
+func (t *simpleTCCTask) release() {
+	atomic.StoreInt32(&t.running, 0)
+}
+
+func (t *simpleTCCTask) children() []Info {
+	return []Info{t.tcc}
 }
 
 func (t *simpleTCCTask) Strict() Task {
@@ -103,20 +144,27 @@ type strictTask struct {
 }
 
 func (s *strictTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	s.SetState(Running)
-	err := s.tcc.Try(ctx, input)
+	ctx, info := startRun(ctx, s)
+	info.SetState(Running)
+	err := s.acquire()
 	if err == nil {
-		err = multierr.Append(err, s.tcc.Confirm(ctx, input))
-	} else {
-		err = multierr.Append(err, s.tcc.Cancel(ctx, input))
+		ctx = beginTransaction(ctx)
+		link(ctx, s, s.tcc)
+		if err = s.tcc.Try(ctx, input); err == nil {
+			err = multierr.Append(err, s.tcc.Confirm(ctx, input))
+		} else {
+			err = multierr.Append(err, s.tcc.Cancel(ctx, input))
+		}
+		s.release()
 	}
-	s.AddError(err)
+	info.AddError(err)
+	markCancelled(ctx, info)
 
 	for _, callback := range s.callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 
 	return err
@@ -127,22 +175,29 @@ type inertTask struct {
 }
 
 func (s *inertTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	s.SetState(Running)
-	err := s.tcc.Try(ctx, input)
+	ctx, info := startRun(ctx, s)
+	info.SetState(Running)
+	err := s.acquire()
 	if err == nil {
-		err = s.tcc.Confirm(ctx, input)
-	} else {
-		s.AddError(err, false)
-		err = s.tcc.Cancel(ctx, input)
+		ctx = beginTransaction(ctx)
+		link(ctx, s, s.tcc)
+		if err = s.tcc.Try(ctx, input); err == nil {
+			err = s.tcc.Confirm(ctx, input)
+		} else {
+			info.AddError(err, false)
+			err = s.tcc.Cancel(ctx, input)
+		}
+		s.release()
 	}
 
-	s.AddError(err)
+	info.AddError(err)
+	markCancelled(ctx, info)
 
 	for _, callback := range s.callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
@@ -3,6 +3,8 @@ package workflow
 import (
 	"context"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSimpleTask(t *testing.T) {
@@ -10,6 +12,44 @@ func TestSimpleTask(t *testing.T) {
 	t.Log(f.ID(), f.Name())
 }
 
+func TestTCCTaskConcurrent(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	task := NewTCCTask(NewTCC(NewFunc(func(context.Context, interface{}) error {
+		close(started)
+		<-release
+		return nil
+	}), NewFunc(UI), NewFunc(UI))).Strict()
+
+	done := make(chan error)
+	go func() { done <- task.Execute(context.Background(), nil) }()
+	<-started
+	// 同一个 TCC 不能同时执行
+	run, err := Run(context.Background(), task, nil)
+	assert.ErrorIs(t, err, ErrTCCRunning)
+	assert.Equal(t, Error, run.State())
+	close(release)
+	assert.NoError(t, <-done)
+}
+
 func UI(ctx context.Context, input interface{}) error {
 	return nil
 }
+
+func TestTCCTaskRerun(t *testing.T) {
+	// 每次 Try 都是新的事务，不保留上次的错误
+	var tries int32
+	group := NewTCCGroup().WithTCCs(NewTCC(failingTask(1, &tries), NewFunc(UI), NewFunc(UI)))
+	task := NewTCCTask(group).Strict()
+	assert.Error(t, task.Execute(context.Background(), nil))
+	assert.NoError(t, task.Execute(context.Background(), nil))
+	assert.NoError(t, task.Execute(context.Background(), nil))
+	assert.Equal(t, Success, group.State())
+
+	tries = 0
+	pipeline := NewTCCPipeline().WithTCCs(NewTCC(failingTask(1, &tries), NewFunc(UI), NewFunc(UI)))
+	assert.Error(t, pipeline.Try(context.Background(), nil))
+	assert.NoError(t, pipeline.Try(context.Background(), nil))
+	assert.NoError(t, pipeline.Confirm(context.Background(), nil))
+	assert.Equal(t, Success, pipeline.State())
+}
@@ -2,8 +2,12 @@ package workflow
 
 import (
 	"context"
+	"errors"
+
+	"go.uber.org/multierr"
 )
 
+// TCC is a transaction instance, its Info keeps the state between Try and Confirm or Cancel
 type TCC interface {
 	Info
 	Try(ctx context.Context, input interface{}, callbacks ...Callback) error
@@ -13,11 +17,15 @@ type TCC interface {
 
 func NewTCC(try, confirm, cancel Task, opts ...Option) TCC {
 	opt := &options{
-		info: DefaultTaskInfo(),
+		info: DefaultTaskInfo(""),
 	}
 	for _, o := range opts {
 		o.apply(opt)
 	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
 	// 初始化状态
 	opt.info.SetName("tcc")
 	opt.info.SetState(Ready)
@@ -28,6 +36,9 @@ func NewTCC(try, confirm, cancel Task, opts ...Option) TCC {
 		confirm:   confirm,
 		cancel:    cancel,
 		callbacks: opt.callbacks,
+		retry:     newPhaseRetry(opt),
+		barrier:   newBarrier(opt.barrier),
+		expiry:    newExpiry(opt),
 	}
 }
 
@@ -37,41 +48,97 @@ type simpleTCC struct {
 	confirm   Task
 	cancel    Task
 	callbacks []Callback
+	retry     *phaseRetry
+	barrier   *barrier
+	expiry    *expiry
+	ownedTransaction
+}
+
+func (s *simpleTCC) children() []Info {
+	return []Info{s.try, s.confirm, s.cancel}
 }
 
 func (s *simpleTCC) Try(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	s.SetState(Running)
-	err := s.try.Execute(ctx, input)
-	s.AddError(err, false)
+	ctx, info := startTry(ctx, s, s.Info)
+	info.SetState(Trying)
+	err := s.phase(PhaseTry, s.try, input)(ctx)
+	if !errors.Is(err, ErrBarrierRejected) {
+		s.expiry.start(ctx, s, input)
+	}
+	err = multierr.Append(err, recordHistory(ctx, s, HistoryTried, err))
+	info.AddError(err, false)
+	markCancelled(ctx, info)
 	for _, callback := range s.callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
 
 func (s *simpleTCC) Confirm(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	err := s.confirm.Execute(ctx, input)
-	s.AddError(err)
+	ctx, info := startPhase(ctx, s, s.Info)
+	run, err := s.expiry.stop(ctx, s, PhaseConfirm)
+	if run {
+		info.SetState(Confirming)
+		err = s.retry.run(ctx, s, PhaseConfirm, input, s.phase(PhaseConfirm, s.confirm, input))
+	}
+	err = multierr.Append(err, recordHistory(ctx, s, HistoryConfirmed, err))
+	info.AddError(err)
+	markCancelled(ctx, info)
 	for _, callback := range s.callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
 
 func (s *simpleTCC) Cancel(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	err := s.cancel.Execute(ctx, input)
-	s.AddError(err)
+	ctx, info := startPhase(ctx, s, s.Info)
+	from := info.State()
+	run, err := s.expiry.stop(ctx, s, PhaseCancel)
+	if run {
+		info.SetState(Cancelling)
+		err = s.retry.run(ctx, s, PhaseCancel, input, s.phase(PhaseCancel, s.cancel, input))
+	}
+	err = multierr.Append(err, recordHistory(ctx, s, HistoryCancelled, err))
+	endCancel(info, from, err)
+	markCancelled(ctx, info)
 	for _, callback := range s.callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, s.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
+
+// phase returns a call of the phase task guarded by the barrier, every retry is a new child step
+func (s *simpleTCC) phase(phase Phase, task Task, input interface{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		run, err := s.barrier.enter(ctx, s, phase)
+		if err != nil || !run {
+			return err
+		}
+		if err = task.Execute(link(ctx, s, task), input); err != nil {
+			err = multierr.Append(err, s.barrier.fail(ctx, s, phase))
+		}
+		return err
+	}
+}
+
+// endCancel records the outcome of a Cancel phase started in state from: Compensated once it undid
+// a confirmed TCC, Cancelled otherwise
+func endCancel(info Info, from State, err error) {
+	switch {
+	case err != nil:
+		info.AddError(err)
+	case from == Success:
+		info.SetState(Compensated)
+	default:
+		info.SetState(Cancelled)
+	}
+}
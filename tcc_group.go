@@ -2,16 +2,23 @@ package workflow
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"sync"
+	"time"
 )
 
 func NewTCCGroup(opts ...Option) *noopTCCGroup {
 	opt := &options{
-		info: DefaultTaskInfo(),
+		info: DefaultTaskInfo(""),
 	}
 	for _, o := range opts {
 		o.apply(opt)
 	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
 	// 初始化状态
 	opt.info.SetName("tcc-group")
 	opt.info.SetState(Ready)
@@ -19,12 +26,102 @@ func NewTCCGroup(opts ...Option) *noopTCCGroup {
 	return &noopTCCGroup{
 		Info:      opt.info,
 		callbacks: opt.callbacks,
+		retry:     newPhaseRetry(opt),
+		phases:    make(map[Phase]*groupPhase),
 	}
 }
 
 type noopTCCGroup struct {
 	Info
-	callbacks []Callback
+	callbacks  []Callback
+	retry      *phaseRetry
+	phases     map[Phase]*groupPhase
+	priorities map[string]int // 分支 id -> 优先级，默认为 0
+}
+
+// Order is the order in which the branches of a TCC group start
+type Order uint8
+
+const (
+	// OrderRegistration starts the branches in the order they were given to WithTCCs
+	OrderRegistration Order = iota
+	// OrderReverse starts the branches in the reverse order of registration
+	OrderReverse
+	// OrderPriority starts the branches by decreasing priority (see WithPriority),
+	// those of the same priority in the order of registration
+	OrderPriority
+)
+
+// groupPhase configures how a phase runs the branches of a TCC group
+type groupPhase struct {
+	concurrency int // 0 表示不限制
+	order       Order
+	timeout     time.Duration
+}
+
+var groupPhases = []Phase{PhaseTry, PhaseConfirm, PhaseCancel}
+
+func (n *noopTCCGroup) phase(phase Phase) *groupPhase {
+	p, ok := n.phases[phase]
+	if !ok {
+		p = &groupPhase{}
+		n.phases[phase] = p
+	}
+	return p
+}
+
+func (n *noopTCCGroup) configure(phases []Phase, f func(p *groupPhase)) *noopTCCGroup {
+	if len(phases) == 0 {
+		phases = groupPhases
+	}
+	for _, phase := range phases {
+		f(n.phase(phase))
+	}
+	return n
+}
+
+// WithConcurrency set how many branches run at the same time in phases, all of them when none is given
+func (n *noopTCCGroup) WithConcurrency(concurrency int, phases ...Phase) *noopTCCGroup {
+	if concurrency <= 0 {
+		return n
+	}
+	return n.configure(phases, func(p *groupPhase) { p.concurrency = concurrency })
+}
+
+// WithOrder set the order in which the branches start in phases, all of them when none is given.
+// With a concurrency of 1 the branches run one after another in that order.
+func (n *noopTCCGroup) WithOrder(order Order, phases ...Phase) *noopTCCGroup {
+	return n.configure(phases, func(p *groupPhase) { p.order = order })
+}
+
+// WithPriority set the priority of the branch id for OrderPriority, the branches default to 0
+func (n *noopTCCGroup) WithPriority(id string, priority int) *noopTCCGroup {
+	if n.priorities == nil {
+		n.priorities = make(map[string]int)
+	}
+	n.priorities[id] = priority
+	return n
+}
+
+// WithTimeout bounds the duration of phases, all of them when none is given
+func (n *noopTCCGroup) WithTimeout(timeout time.Duration, phases ...Phase) *noopTCCGroup {
+	return n.configure(phases, func(p *groupPhase) { p.timeout = timeout })
+}
+
+// context returns the context of phase, bounded by its timeout
+func (n *noopTCCGroup) context(ctx context.Context, phase Phase) (context.Context, context.CancelFunc) {
+	if p := n.phases[phase]; p != nil && p.timeout > 0 {
+		return context.WithTimeout(ctx, p.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// markTimedOut sets TimedOut on info when phaseCtx of a failed phase passed its deadline
+// while ctx is still running
+func markTimedOut(ctx, phaseCtx context.Context, info Info) {
+	if ctx.Err() == nil && errors.Is(phaseCtx.Err(), context.DeadlineExceeded) && info.Error() != nil {
+		info.SetState(TimedOut)
+	}
 }
 
 func (n *noopTCCGroup) WithTCCs(tccs ...TCC) TCC {
@@ -38,7 +135,7 @@ func (n *noopTCCGroup) WithTCCs(tccs ...TCC) TCC {
 	return &tccGroup{
 		noopTCCGroup: n,
 		tccs:         markedTccs,
-		errOnce:      sync.Once{},
+		reports:      newBranchReports(tccs),
 	}
 }
 
@@ -50,98 +147,187 @@ type markedTCC struct {
 type tccGroup struct {
 	*noopTCCGroup
 	tccs    []*markedTCC
-	errOnce sync.Once
+	reports *branchReports
+	ownedTransaction
+}
+
+func (t *tccGroup) children() []Info {
+	children := make([]Info, 0, len(t.tccs))
+	for _, tcc := range t.tccs {
+		children = append(children, tcc.TCC)
+	}
+	return children
 }
 
-func (t *tccGroup) doTry(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup,
+// dispatch runs f for the branches of phase in its order, at most its concurrency at a time.
+// Confirm and Cancel only run the branches that tried.
+func (t *tccGroup) dispatch(phase Phase, f func(index int, task *markedTCC)) {
+	p := t.phases[phase]
+	if p == nil {
+		p = &groupPhase{}
+	}
+	var (
+		wg  sync.WaitGroup
+		sem chan struct{}
+	)
+	if p.concurrency > 0 {
+		sem = make(chan struct{}, p.concurrency)
+	}
+	for _, i := range t.order(p.order) {
+		index, task := i, t.tccs[i]
+		if phase != PhaseTry && !task.marked {
+			continue
+		}
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				if sem != nil {
+					<-sem
+				}
+				wg.Done()
+			}()
+			f(index, task)
+		}()
+	}
+	wg.Wait()
+}
+
+// order returns the indexes of the branches in the order o
+func (t *tccGroup) order(o Order) []int {
+	indexes := make([]int, len(t.tccs))
+	for i := range indexes {
+		indexes[i] = i
+		if o == OrderReverse {
+			indexes[i] = len(t.tccs) - 1 - i
+		}
+	}
+	if o == OrderPriority {
+		sort.SliceStable(indexes, func(i, j int) bool {
+			return t.priorities[t.tccs[indexes[i]].ID()] > t.priorities[t.tccs[indexes[j]].ID()]
+		})
+	}
+	return indexes
+}
+
+func (t *tccGroup) doTry(ctx context.Context, cancel context.CancelFunc, info Info,
 	index int, task *markedTCC, input interface{}) {
-	defer wg.Done()
 	var err error
 	select {
 	case <-ctx.Done():
 		err = ctx.Err()
 	default:
+		start := time.Now()
 		err = task.Try(ctx, input)
+		t.reports.record(index, PhaseTry, start, err)
 		if err != nil {
-			t.errOnce.Do(cancel)
+			cancel()
 		}
 		// 标记已经执行的
 		task.marked = true
 	}
-	t.AddError(err, false)
+	info.AddError(err, false)
 }
 
 func (t *tccGroup) Try(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	t.SetState(Running)
-	newCtx, cancel := context.WithCancel(ctx)
-	var wg sync.WaitGroup
-	for index, tcc := range t.tccs {
-		wg.Add(1)
-		go t.doTry(newCtx, cancel, &wg, index, tcc, input)
+	ctx, info := startTry(ctx, t, t.Info)
+	info.SetState(Trying)
+	t.reports.reset()
+	// 每次 Try 重新标记，Confirm 和 Cancel 只处理本次尝试过的分支
+	for _, task := range t.tccs {
+		task.marked = false
 	}
-	wg.Wait()
-	t.errOnce.Do(cancel)
+	newCtx, cancel := t.context(ctx, PhaseTry)
+	defer cancel()
+	// 按注册顺序调度，保证子步骤顺序确定
+	for _, tcc := range t.tccs {
+		link(ctx, t, tcc)
+	}
+	t.dispatch(PhaseTry, func(index int, task *markedTCC) {
+		t.doTry(newCtx, cancel, info, index, task, input)
+	})
+	cancel()
+	markTimedOut(ctx, newCtx, info)
+	markCancelled(ctx, info)
+	t.reports.publish(info, TransactionID(ctx))
 
-	err := t.Error()
+	err := info.Error()
 	for _, callback := range t.callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
 
-func (t *tccGroup) doConfirm(ctx context.Context, wg *sync.WaitGroup,
-	task *markedTCC, input interface{}) {
-	err := task.Confirm(ctx, input)
-	t.AddError(err)
-	wg.Done()
+func (t *tccGroup) doConfirm(ctx context.Context, info Info, index int, task *markedTCC, input interface{}) {
+	start := time.Now()
+	err := t.retry.run(ctx, task.TCC, PhaseConfirm, input, func(ctx context.Context) error {
+		return task.Confirm(ctx, input)
+	})
+	t.reports.record(index, PhaseConfirm, start, err)
+	if err != nil {
+		info.AddError(err)
+	}
 }
 
 func (t *tccGroup) Confirm(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	var wg sync.WaitGroup
-	for _, tcc := range t.tccs {
-		if !tcc.marked {
-			continue
-		}
-		wg.Add(1)
-		go t.doConfirm(ctx, &wg, tcc, input)
-	}
-	wg.Wait()
-	err := t.Error()
+	ctx, info := startPhase(ctx, t, t.Info)
+	info.SetState(Confirming)
+	phaseCtx, cancel := t.context(ctx, PhaseConfirm)
+	t.dispatch(PhaseConfirm, func(index int, task *markedTCC) {
+		t.doConfirm(phaseCtx, info, index, task, input)
+	})
+	cancel()
+	info.AddError(nil)
+	markTimedOut(ctx, phaseCtx, info)
+	markCancelled(ctx, info)
+	t.reports.publish(info, TransactionID(ctx))
+	err := info.Error()
 	for _, callback := range t.callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
 
-func (t *tccGroup) doCancel(ctx context.Context, wg *sync.WaitGroup,
-	task *markedTCC, input interface{}) {
-	err := task.Confirm(ctx, input)
-	t.AddError(err)
-	wg.Done()
+func (t *tccGroup) doCancel(ctx context.Context, info Info, index int, task *markedTCC, input interface{}) {
+	start := time.Now()
+	err := t.retry.run(ctx, task.TCC, PhaseCancel, input, func(ctx context.Context) error {
+		return task.Cancel(ctx, input)
+	})
+	t.reports.record(index, PhaseCancel, start, err)
+	if err != nil {
+		info.AddError(err)
+	}
 }
 
 func (t *tccGroup) Cancel(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	var wg sync.WaitGroup
-	for _, tcc := range t.tccs {
-		if !tcc.marked {
-			continue
-		}
-		wg.Add(1)
-		go t.doCancel(ctx, &wg, tcc, input)
+	ctx, info := startPhase(ctx, t, t.Info)
+	from := info.State()
+	info.SetState(Cancelling)
+	phaseCtx, cancel := t.context(ctx, PhaseCancel)
+	t.dispatch(PhaseCancel, func(index int, task *markedTCC) {
+		t.doCancel(phaseCtx, info, index, task, input)
+	})
+	cancel()
+	if info.State() != Error {
+		endCancel(info, from, nil)
 	}
-	wg.Wait()
-	err := t.Error()
+	markTimedOut(ctx, phaseCtx, info)
+	markCancelled(ctx, info)
+	t.reports.publish(info, TransactionID(ctx))
+	err := info.Error()
 	for _, callback := range t.callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
@@ -0,0 +1,92 @@
+package workflow
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTCCGroupConcurrency(t *testing.T) {
+	var running, peak, tries int32
+	try := NewFunc(func(context.Context, interface{}) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(2 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&tries, 1)
+		return nil
+	})
+	tccs := make([]TCC, 0, 20)
+	for i := 0; i < 20; i++ {
+		tccs = append(tccs, NewTCC(try, NewFunc(UI), NewFunc(UI)))
+	}
+	group := NewTCCGroup().WithConcurrency(3).WithTCCs(tccs...)
+	require.NoError(t, group.Try(context.Background(), nil))
+	assert.Equal(t, int32(20), tries)
+	assert.LessOrEqual(t, peak, int32(3))
+}
+
+func TestTCCGroupOrder(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		phases []string
+	)
+	branch := func(name string) TCC {
+		record := func(phase string) Task {
+			return NewFunc(func(context.Context, interface{}) error {
+				mutex.Lock()
+				phases = append(phases, phase+" "+name)
+				mutex.Unlock()
+				return nil
+			})
+		}
+		return NewTCC(record("try"), record("confirm"), record("cancel"))
+	}
+	group := NewTCCGroup().
+		WithConcurrency(1).
+		WithOrder(OrderReverse, PhaseCancel).
+		WithTCCs(branch("a"), branch("b"), branch("c"))
+
+	require.NoError(t, group.Try(context.Background(), nil))
+	require.NoError(t, group.Cancel(context.Background(), nil))
+	assert.Equal(t, []string{"try a", "try b", "try c", "cancel c", "cancel b", "cancel a"}, phases)
+
+	// 按优先级从高到低，同优先级按注册顺序
+	phases = nil
+	a, b, c := branch("a"), branch("b"), branch("c")
+	group = NewTCCGroup().
+		WithConcurrency(1).
+		WithOrder(OrderPriority, PhaseTry).
+		WithPriority(c.ID(), 2).
+		WithPriority(a.ID(), -1).
+		WithTCCs(a, b, c, branch("d"))
+	require.NoError(t, group.Try(context.Background(), nil))
+	require.NoError(t, group.Confirm(context.Background(), nil))
+	assert.Equal(t, []string{"try c", "try b", "try d", "try a", "confirm a", "confirm b", "confirm c", "confirm d"}, phases)
+}
+
+func TestTCCGroupTimeout(t *testing.T) {
+	var cancels int32
+	slow := NewTCC(NewFunc(func(ctx context.Context, _ interface{}) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), NewFunc(UI), failingTask(0, &cancels))
+	group := NewTCCGroup().WithTimeout(20*time.Millisecond, PhaseTry).WithTCCs(slow)
+
+	start := time.Now()
+	assert.ErrorIs(t, group.Try(context.Background(), nil), context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+	// 超时只作用于 Try
+	_ = group.Cancel(context.Background(), nil)
+	assert.Equal(t, int32(1), cancels)
+}
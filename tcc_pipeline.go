@@ -2,15 +2,21 @@ package workflow
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
 func NewTCCPipeline(opts ...Option) *noopTCCPipeline {
 	opt := &options{
-		info: DefaultTaskInfo(),
+		info: DefaultTaskInfo(""),
 	}
 	for _, o := range opts {
 		o.apply(opt)
 	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
 	// 初始化状态
 	opt.info.SetName("tcc-pipeline")
 	opt.info.SetState(Ready)
@@ -18,12 +24,14 @@ func NewTCCPipeline(opts ...Option) *noopTCCPipeline {
 	return &noopTCCPipeline{
 		Info:      opt.info,
 		callbacks: opt.callbacks,
+		retry:     newPhaseRetry(opt),
 	}
 }
 
 type noopTCCPipeline struct {
 	Info
 	callbacks []Callback
+	retry     *phaseRetry
 }
 
 func (n *noopTCCPipeline) WithTCCs(tccs ...TCC) TCC {
@@ -31,61 +39,130 @@ func (n *noopTCCPipeline) WithTCCs(tccs ...TCC) TCC {
 		noopTCCPipeline: n,
 		tccs:            tccs,
 		cur:             0,
+		reports:         newBranchReports(tccs),
 	}
 }
 
 type tccPipeline struct {
 	*noopTCCPipeline
-	tccs []TCC
-	cur  int
+	tccs    []TCC
+	cur     int
+	reports *branchReports
+	ownedTransaction
+}
+
+func (t *tccPipeline) children() []Info {
+	children := make([]Info, 0, len(t.tccs))
+	for _, tcc := range t.tccs {
+		children = append(children, tcc)
+	}
+	return children
 }
 
 func (t *tccPipeline) Try(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	for index, tcc := range t.tccs {
+	ctx, info := startTry(ctx, t, t.Info)
+	info.SetState(Trying)
+	var suspended bool
+	t.reports.reset()
+	start := startStep(ctx, t)
+	if start > 0 {
+		// 恢复的执行中之前的分支已经 Try 过，Cancel 时一并回滚
+		t.cur = start - 1
+	}
+	for index := start; index < len(t.tccs); index++ {
+		// 步骤之间检查执行是否已被取消或暂停
+		if err := ctx.Err(); err != nil {
+			info.AddError(err, false)
+			break
+		}
+		if err := stepBoundary(ctx, t, index); err != nil {
+			info.AddError(err, false)
+			break
+		}
 		t.cur = index
-		if err := tcc.Try(ctx, input); err != nil {
-			t.AddError(err, false)
+		link(ctx, t, t.tccs[index])
+		start := time.Now()
+		err := t.tccs[index].Try(ctx, input)
+		t.reports.record(index, PhaseTry, start, err)
+		if err != nil {
+			if errors.Is(err, ErrSuspended) {
+				suspended = true
+				info.SetState(Waiting)
+				break
+			}
+			info.AddError(err, false)
 			break
 		}
 	}
-	err := t.Error()
+	stepsDone(ctx, t)
+	markCancelled(ctx, info)
+	t.reports.publish(info, TransactionID(ctx))
+	err := info.Error()
+	if suspended {
+		err = ErrSuspended
+	}
 	for _, callback := range t.callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
 
 func (t *tccPipeline) Confirm(ctx context.Context, input interface{}, callbacks ...Callback) error {
-	for _, tcc := range t.tccs {
-		if err := tcc.Confirm(ctx, input); err != nil {
-			t.AddError(err)
+	ctx, info := startPhase(ctx, t, t.Info)
+	info.SetState(Confirming)
+	for index, tcc := range t.tccs {
+		tcc := tcc
+		start := time.Now()
+		err := t.retry.run(ctx, tcc, PhaseConfirm, input, func(ctx context.Context) error {
+			return tcc.Confirm(ctx, input)
+		})
+		t.reports.record(index, PhaseConfirm, start, err)
+		if err != nil {
+			info.AddError(err)
 		}
 	}
-	err := t.Error()
+	info.AddError(nil)
+	markCancelled(ctx, info)
+	t.reports.publish(info, TransactionID(ctx))
+	err := info.Error()
 	for _, callback := range t.callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
 
 func (t *tccPipeline) Cancel(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startPhase(ctx, t, t.Info)
+	from := info.State()
+	info.SetState(Cancelling)
 	for i := t.cur; i >= 0; i-- {
-		if err := t.tccs[i].Cancel(ctx, input); err != nil {
-			t.AddError(err)
+		tcc := t.tccs[i]
+		start := time.Now()
+		err := t.retry.run(ctx, tcc, PhaseCancel, input, func(ctx context.Context) error {
+			return tcc.Cancel(ctx, input)
+		})
+		t.reports.record(i, PhaseCancel, start, err)
+		if err != nil {
+			info.AddError(err)
 		}
 	}
-	err := t.Error()
+	if info.State() != Error {
+		endCancel(info, from, nil)
+	}
+	markCancelled(ctx, info)
+	t.reports.publish(info, TransactionID(ctx))
+	err := info.Error()
 	for _, callback := range t.callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	for _, callback := range callbacks {
-		callback.Trigger(ctx, t.Info, input, err)
+		callback.Trigger(ctx, info, input, err)
 	}
 	return err
 }
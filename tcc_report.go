@@ -0,0 +1,106 @@
+package workflow
+
+import (
+	"sync"
+	"time"
+)
+
+// PhaseReport is the outcome of a phase of a branch, Duration includes its retries
+type PhaseReport struct {
+	Phase    Phase         `json:"phase"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// BranchReport is the outcome of a branch of a TCC composite, Phase is the last phase it reached
+// and is empty when the branch did not run
+type BranchReport struct {
+	ID     string        `json:"id"`
+	Name   string        `json:"name"`
+	State  State         `json:"state"`
+	Phase  Phase         `json:"phase,omitempty"`
+	Phases []PhaseReport `json:"phases"`
+}
+
+// reportMetaKey is the metadata key of the TransactionReport of a TCC composite
+const reportMetaKey = "report"
+
+// TransactionReport is the metadata of a TCC group or pipeline under the "report" key,
+// the branches are in their order
+type TransactionReport struct {
+	ID            string         `json:"id"`
+	Name          string         `json:"name"`
+	TransactionID string         `json:"transaction_id,omitempty"`
+	State         State          `json:"state"`
+	Branches      []BranchReport `json:"branches"`
+}
+
+// Report returns the TransactionReport of the last phase of the TCC composite tcc
+func Report(tcc TCC) (*TransactionReport, bool) {
+	var report TransactionReport
+	if ok, err := MetaOf(tcc).Get(reportMetaKey, &report); !ok || err != nil || report.Branches == nil {
+		return nil, false
+	}
+	return &report, true
+}
+
+// branchReports records the phases of the branches of a TCC composite
+type branchReports struct {
+	mutex    sync.Mutex
+	branches []TCC
+	phases   [][]PhaseReport
+}
+
+func newBranchReports(branches []TCC) *branchReports {
+	return &branchReports{
+		branches: branches,
+		phases:   make([][]PhaseReport, len(branches)),
+	}
+}
+
+// reset forgets the phases of a previous transaction
+func (b *branchReports) reset() {
+	b.mutex.Lock()
+	b.phases = make([][]PhaseReport, len(b.branches))
+	b.mutex.Unlock()
+}
+
+func (b *branchReports) record(index int, phase Phase, start time.Time, err error) {
+	report := PhaseReport{
+		Phase:    phase,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	b.mutex.Lock()
+	b.phases[index] = append(b.phases[index], report)
+	b.mutex.Unlock()
+}
+
+// publish sets the report of the composite info in the global transaction gid under the "report"
+// key of its metadata, before the callbacks are triggered
+func (b *branchReports) publish(info Info, gid string) {
+	report := TransactionReport{
+		ID:            info.ID(),
+		Name:          info.Name(),
+		TransactionID: gid,
+		State:         info.State(),
+		Branches:      make([]BranchReport, 0, len(b.branches)),
+	}
+	b.mutex.Lock()
+	for i, branch := range b.branches {
+		br := BranchReport{
+			ID:     branch.ID(),
+			Name:   branch.Name(),
+			State:  branch.State(),
+			Phases: append([]PhaseReport{}, b.phases[i]...),
+		}
+		if len(br.Phases) > 0 {
+			br.Phase = br.Phases[len(br.Phases)-1].Phase
+		}
+		report.Branches = append(report.Branches, br)
+	}
+	b.mutex.Unlock()
+	_ = SetMeta(info, reportMetaKey, report)
+}
@@ -0,0 +1,80 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func namedTCC(name string, try Task) TCC {
+	info := DefaultTaskInfo(name)
+	tcc := NewTCC(try, NewFunc(UI), NewFunc(UI), WithInfo(info))
+	tcc.SetName(name)
+	return tcc
+}
+
+func TestTCCGroupReport(t *testing.T) {
+	var reported *TransactionReport
+	group := NewTCCGroup(WithMeta("id", "order-1"), WithCallbacks(callbackFunc(func(_ context.Context, info Info, _ interface{}, _ error) {
+		var report TransactionReport
+		ok, err := MetaOf(info).Get("report", &report)
+		require.True(t, ok)
+		require.NoError(t, err)
+		reported = &report
+	}))).WithConcurrency(1).WithTCCs(
+		namedTCC("stock", NewFunc(UI)),
+		namedTCC("payment", NewFunc(func(context.Context, interface{}) error { return errors.New("declined") })),
+	)
+
+	ctx := WithTransactionID(context.Background(), "gid-1")
+	require.Error(t, group.Try(ctx, nil))
+	require.NotNil(t, reported)
+	assert.Equal(t, "gid-1", reported.TransactionID)
+	assert.Equal(t, PhaseTry, reported.Branches[1].Phase)
+	assert.Equal(t, "declined", reported.Branches[1].Phases[0].Error)
+
+	_ = group.Cancel(ctx, nil)
+	report, ok := Report(group)
+	require.True(t, ok)
+	assert.Equal(t, group.ID(), report.ID)
+	require.Len(t, report.Branches, 2)
+	// 并发为 1 时 stock 先 Try 成功，随后被取消
+	stock := report.Branches[0]
+	assert.Equal(t, "stock", stock.Name)
+	assert.Equal(t, PhaseCancel, stock.Phase)
+	require.Len(t, stock.Phases, 2)
+	assert.Equal(t, PhaseTry, stock.Phases[0].Phase)
+	payment := report.Branches[1]
+	assert.Equal(t, "payment", payment.ID)
+	assert.Equal(t, PhaseCancel, payment.Phase)
+	require.Len(t, payment.Phases, 2)
+	assert.Equal(t, PhaseTry, payment.Phases[0].Phase)
+	assert.Empty(t, payment.Phases[1].Error)
+	assert.Equal(t, reported, report)
+	// 报告不会覆盖 WithMeta 设置的键
+	assert.Equal(t, "order-1", MetaOf(group).String("id"))
+}
+
+func TestTCCPipelineReport(t *testing.T) {
+	pipeline := NewTCCPipeline().WithTCCs(
+		namedTCC("stock", NewFunc(UI)),
+		namedTCC("payment", NewFunc(func(context.Context, interface{}) error { return errors.New("declined") })),
+		namedTCC("shipping", NewFunc(UI)),
+	)
+	require.Error(t, pipeline.Try(WithTransactionID(context.Background(), "gid-1"), nil))
+	report, ok := Report(pipeline)
+	require.True(t, ok)
+	assert.Equal(t, "gid-1", report.TransactionID)
+	assert.Equal(t, PhaseTry, report.Branches[0].Phase)
+	assert.Equal(t, Trying, report.Branches[0].State)
+	assert.Equal(t, "declined", report.Branches[1].Phases[0].Error)
+	assert.Equal(t, Ready, report.Branches[2].State)
+	assert.Empty(t, report.Branches[2].Phase)
+	assert.Empty(t, report.Branches[2].Phases)
+
+	_, ok = Report(NewTCC(NewFunc(UI), NewFunc(UI), NewFunc(UI)))
+	assert.False(t, ok)
+}
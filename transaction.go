@@ -0,0 +1,112 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// TransactionHeader carries the global transaction id to remote participants
+const TransactionHeader = "X-Transaction-Id"
+
+type transactionKey struct{}
+
+// WithTransactionID joins ctx to the global transaction gid
+func WithTransactionID(ctx context.Context, gid string) context.Context {
+	return context.WithValue(ctx, transactionKey{}, gid)
+}
+
+// TransactionID returns the global transaction of ctx, empty when none was set.
+// It is available to the tasks of every nested Try, Confirm and Cancel.
+func TransactionID(ctx context.Context) string {
+	gid, _ := ctx.Value(transactionKey{}).(string)
+	return gid
+}
+
+// beginTransaction starts a new global transaction for the phases of the TCCs run with ctx,
+// unless ctx already has one
+func beginTransaction(ctx context.Context) context.Context {
+	if TransactionID(ctx) != "" {
+		return ctx
+	}
+	return WithTransactionID(ctx, uuid.NewV4().String())
+}
+
+// ownedTransaction keeps the global transaction an outermost TCC started with its Try, so that
+// its Confirm or Cancel called without one join it
+type ownedTransaction struct {
+	mutex sync.Mutex
+	gid   string
+}
+
+// tryTransaction joins ctx to a new global transaction of the TCC unless ctx has one
+func (o *ownedTransaction) tryTransaction(ctx context.Context) context.Context {
+	if TransactionID(ctx) != "" {
+		return ctx
+	}
+	gid := uuid.NewV4().String()
+	o.mutex.Lock()
+	o.gid = gid
+	o.mutex.Unlock()
+	return WithTransactionID(ctx, gid)
+}
+
+// phaseTransaction joins ctx to the global transaction of the last Try of the TCC unless ctx has one,
+// a new one when the TCC was never tried
+func (o *ownedTransaction) phaseTransaction(ctx context.Context) context.Context {
+	if TransactionID(ctx) != "" {
+		return ctx
+	}
+	o.mutex.Lock()
+	if o.gid == "" {
+		o.gid = uuid.NewV4().String()
+	}
+	gid := o.gid
+	o.mutex.Unlock()
+	return WithTransactionID(ctx, gid)
+}
+
+type transactionOwner interface {
+	tryTransaction(ctx context.Context) context.Context
+	phaseTransaction(ctx context.Context) context.Context
+}
+
+// startTransaction joins the Try of t to the global transaction of ctx, a new one kept by t when ctx has none
+func startTransaction(ctx context.Context, t TCC) context.Context {
+	if o, ok := t.(transactionOwner); ok {
+		return o.tryTransaction(ctx)
+	}
+	return beginTransaction(ctx)
+}
+
+// joinTransaction joins a phase of t to the global transaction of ctx, the one kept by t when ctx has none
+func joinTransaction(ctx context.Context, t TCC) context.Context {
+	if o, ok := t.(transactionOwner); ok {
+		return o.phaseTransaction(ctx)
+	}
+	return beginTransaction(ctx)
+}
+
+// InjectTransaction sets the global transaction of ctx in header
+func InjectTransaction(ctx context.Context, header http.Header) {
+	if gid := TransactionID(ctx); gid != "" {
+		header.Set(TransactionHeader, gid)
+	}
+}
+
+// ExtractTransaction joins ctx to the global transaction carried by header
+func ExtractTransaction(ctx context.Context, header http.Header) context.Context {
+	if gid := header.Get(TransactionHeader); gid != "" {
+		return WithTransactionID(ctx, gid)
+	}
+	return ctx
+}
+
+// TransactionMiddleware joins the requests handled by next to the global transaction of their header
+func TransactionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(ExtractTransaction(r.Context(), r.Header)))
+	})
+}
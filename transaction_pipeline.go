@@ -0,0 +1,199 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/multierr"
+)
+
+// Step is a step of a transaction pipeline: a plain task, a TCC or a saga step
+type Step struct {
+	task       Task // 普通任务或 saga 的动作
+	compensate Task // saga 的补偿
+	tcc        TCC
+}
+
+// TaskStep is a step without compensation, it runs after the Trys of the pipeline
+func TaskStep(t Task) Step {
+	return Step{task: t}
+}
+
+// TCCStep is tried before the tasks of the pipeline and confirmed after them
+func TCCStep(t TCC) Step {
+	return Step{tcc: t}
+}
+
+// SagaStep runs action with the tasks of the pipeline, compensate undoes it when a later step fails
+func SagaStep(action, compensate Task) Step {
+	return Step{task: action, compensate: compensate}
+}
+
+// NewTransactionPipeline runs steps of different kinds as one transaction: the Trys of all the TCC steps
+// first, then the plain tasks and saga actions in order, then the Confirms. When a Try or a task fails,
+// the TCCs that tried are cancelled and the saga steps that ran are compensated, in the reverse order
+// of the steps. A failed Confirm is not compensated, see WithPhaseRetry. The steps share the global
+// transaction of ctx, a new one is started when ctx has none.
+func NewTransactionPipeline(opts ...Option) *noopTransactionPipeline {
+	opt := &options{
+		info: DefaultTaskInfo(""),
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
+	// 初始化状态
+	opt.info.SetName("transaction-pipeline")
+	opt.info.SetState(Ready)
+	opt.info.SetDescription("transaction pipeline")
+	return &noopTransactionPipeline{
+		Info:      opt.info,
+		callbacks: opt.callbacks,
+	}
+}
+
+type noopTransactionPipeline struct {
+	Info
+	callbacks []Callback
+}
+
+func (p *noopTransactionPipeline) WithSteps(steps ...Step) Task {
+	return &transactionPipeline{
+		noopTransactionPipeline: p,
+		steps:                   steps,
+	}
+}
+
+type transactionPipeline struct {
+	*noopTransactionPipeline
+	steps []Step
+}
+
+func (t *transactionPipeline) children() []Info {
+	children := make([]Info, 0, len(t.steps))
+	for _, step := range t.steps {
+		if step.tcc != nil {
+			children = append(children, step.tcc)
+			continue
+		}
+		children = append(children, step.task)
+		if step.compensate != nil {
+			children = append(children, step.compensate)
+		}
+	}
+	return children
+}
+
+func (t *transactionPipeline) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, t)
+	info.SetState(Running)
+	// 所有步骤属于同一个全局事务
+	ctx = beginTransaction(ctx)
+	// 已 Try 的 TCC 或已执行的 saga 动作，失败时需要补偿
+	done := make([]bool, len(t.steps))
+	start := startStep(ctx, t)
+	err := t.try(ctx, input, start, done)
+	if err == nil {
+		err = t.run(ctx, input, start, done)
+	}
+	stepsDone(ctx, t)
+	switch {
+	case err == nil:
+		err = t.confirm(ctx, input)
+	case errors.Is(err, ErrSuspended):
+		// 挂起的步骤恢复后继续，不做补偿
+		info.SetState(Waiting)
+	default:
+		err = multierr.Append(err, t.compensate(detachedContext{ctx}, input, done))
+	}
+	if !errors.Is(err, ErrSuspended) {
+		info.AddError(err)
+	}
+	markCancelled(ctx, info)
+	for _, callback := range t.callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	for _, callback := range callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	return err
+}
+
+// try tries the TCC steps, the Trys take the positions before len(t.steps) of a resumed pipeline
+func (t *transactionPipeline) try(ctx context.Context, input interface{}, start int, done []bool) error {
+	for index, step := range t.steps {
+		if step.tcc == nil {
+			continue
+		}
+		if index < start {
+			// 恢复的执行中之前的分支已经 Try 过，失败时一并回滚
+			done[index] = true
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := stepBoundary(ctx, t, index); err != nil {
+			return err
+		}
+		link(ctx, t, step.tcc)
+		// 失败的 Try 同样需要 Cancel
+		done[index] = true
+		if err := step.tcc.Try(ctx, input); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// run executes the task steps, they take the positions from len(t.steps)
+func (t *transactionPipeline) run(ctx context.Context, input interface{}, start int, done []bool) error {
+	for index, step := range t.steps {
+		if step.task == nil {
+			continue
+		}
+		if len(t.steps)+index < start {
+			done[index] = step.compensate != nil
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := stepBoundary(ctx, t, len(t.steps)+index); err != nil {
+			return err
+		}
+		if err := step.task.Execute(link(ctx, t, step.task), input); err != nil {
+			return err
+		}
+		done[index] = step.compensate != nil
+	}
+	return nil
+}
+
+func (t *transactionPipeline) confirm(ctx context.Context, input interface{}) error {
+	var err error
+	for _, step := range t.steps {
+		if step.tcc != nil {
+			err = multierr.Append(err, step.tcc.Confirm(ctx, input))
+		}
+	}
+	return err
+}
+
+func (t *transactionPipeline) compensate(ctx context.Context, input interface{}, done []bool) error {
+	var err error
+	for index := len(t.steps) - 1; index >= 0; index-- {
+		step := t.steps[index]
+		switch {
+		case !done[index]:
+		case step.tcc != nil:
+			err = multierr.Append(err, step.tcc.Cancel(ctx, input))
+		default:
+			err = multierr.Append(err, step.compensate.Execute(link(ctx, t, step.compensate), input))
+		}
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stepRecorder struct {
+	events []string
+}
+
+func (r *stepRecorder) task(event string, fail bool) Task {
+	return NewFunc(func(context.Context, interface{}) error {
+		r.events = append(r.events, event)
+		if fail {
+			return errors.New(event)
+		}
+		return nil
+	})
+}
+
+// gids records the global transaction of every step
+func (r *stepRecorder) gids(gids map[string]bool) Task {
+	return NewFunc(func(ctx context.Context, _ interface{}) error {
+		gids[TransactionID(ctx)] = true
+		return nil
+	})
+}
+
+func (r *stepRecorder) tcc(name string, fail bool) TCC {
+	return NewTCC(r.task("try "+name, fail), r.task("confirm "+name, false), r.task("cancel "+name, false))
+}
+
+func TestTransactionPipeline(t *testing.T) {
+	r := &stepRecorder{}
+	pipeline := NewTransactionPipeline().WithSteps(
+		TCCStep(r.tcc("a", false)),
+		TaskStep(r.task("task b", false)),
+		SagaStep(r.task("do c", false), r.task("undo c", false)),
+		TCCStep(r.tcc("d", false)),
+	)
+	run, err := Run(context.Background(), pipeline, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Success, run.State())
+	assert.Equal(t, []string{"try a", "try d", "task b", "do c", "confirm a", "confirm d"}, r.events)
+}
+
+func TestTransactionPipelineCompensation(t *testing.T) {
+	r := &stepRecorder{}
+	pipeline := NewTransactionPipeline().WithSteps(
+		TCCStep(r.tcc("a", false)),
+		SagaStep(r.task("do b", false), r.task("undo b", false)),
+		TCCStep(r.tcc("c", false)),
+		TaskStep(r.task("task d", true)),
+		SagaStep(r.task("do e", false), r.task("undo e", false)),
+	)
+	run, err := Run(context.Background(), pipeline, nil)
+	assert.EqualError(t, err, "task d")
+	assert.Equal(t, Error, run.State())
+	assert.Equal(t, []string{"try a", "try c", "do b", "task d", "cancel c", "undo b", "cancel a"}, r.events)
+
+	// Try 失败时任务不会执行
+	r.events = nil
+	pipeline = NewTransactionPipeline().WithSteps(
+		TCCStep(r.tcc("a", false)),
+		SagaStep(r.task("do b", false), r.task("undo b", false)),
+		TCCStep(r.tcc("c", true)),
+		TCCStep(r.tcc("d", false)),
+	)
+	assert.EqualError(t, pipeline.Execute(context.Background(), nil), "try c")
+	assert.Equal(t, []string{"try a", "try c", "cancel c", "cancel a"}, r.events)
+}
+
+func TestTransactionPipelineTransaction(t *testing.T) {
+	r := &stepRecorder{}
+	gids := make(map[string]bool)
+	pipeline := NewTransactionPipeline().WithSteps(
+		TCCStep(NewTCC(r.gids(gids), r.gids(gids), r.gids(gids))),
+		TaskStep(r.gids(gids)),
+		TCCStep(NewTCC(r.gids(gids), r.gids(gids), r.gids(gids))),
+	)
+	require.NoError(t, pipeline.Execute(context.Background(), nil))
+	assert.Len(t, gids, 1)
+	assert.NotContains(t, gids, "")
+
+	for gid := range gids {
+		delete(gids, gid)
+	}
+	require.NoError(t, pipeline.Execute(WithTransactionID(context.Background(), "gid-1"), nil))
+	assert.Equal(t, map[string]bool{"gid-1": true}, gids)
+}
+
+func TestTransactionPipelineResume(t *testing.T) {
+	r := &stepRecorder{}
+	steps := []Step{
+		TCCStep(r.tcc("a", false)),
+		TaskStep(r.task("task b", false)),
+		SagaStep(r.task("do c", false), r.task("undo c", false)),
+		TCCStep(r.tcc("d", false)),
+		TaskStep(r.task("task e", true)),
+	}
+	pipeline := NewTransactionPipeline(WithInfo(DefaultTaskInfo("pipeline"))).WithSteps(steps...)
+	store := NewMemoryCheckpointStore()
+	// 之前的进程 Try 了所有分支并执行到任务 c
+	require.NoError(t, store.Save(context.Background(), &Checkpoint{
+		ExecutionID: "exec-1",
+		Steps:       map[string]int{"pipeline": len(steps) + 2},
+	}))
+	err := NewRegistry(WithCheckpointStore(store)).Execute(context.Background(), "exec-1", pipeline, nil)
+	assert.EqualError(t, err, "task e")
+	assert.Equal(t, []string{"do c", "task e", "cancel d", "undo c", "cancel a"}, r.events)
+}
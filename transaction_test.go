@@ -0,0 +1,98 @@
+package workflow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransactionID(t *testing.T) {
+	var (
+		mutex sync.Mutex
+		gids  []string
+	)
+	record := NewFunc(func(ctx context.Context, _ interface{}) error {
+		mutex.Lock()
+		gids = append(gids, TransactionID(ctx))
+		mutex.Unlock()
+		return nil
+	})
+	branch := func() TCC { return NewTCC(record, record, record) }
+	tcc := NewTCCPipeline().WithTCCs(branch(), NewTCCGroup().WithTCCs(branch(), branch()))
+
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	require.NoError(t, tcc.Confirm(context.Background(), nil))
+	require.Len(t, gids, 6)
+	// 最外层的 Try 开启全局事务，嵌套的分支以及之后的 Confirm 共享它
+	assert.Equal(t, []string{gids[0], gids[0], gids[0], gids[0], gids[0], gids[0]}, gids)
+	assert.NotEmpty(t, gids[0])
+	assert.NotEqual(t, tcc.ID(), gids[0])
+
+	// 下一次 Try 是新的全局事务
+	gids = nil
+	require.NoError(t, tcc.Try(context.Background(), nil))
+	require.NoError(t, tcc.Cancel(context.Background(), nil))
+	require.Len(t, gids, 6)
+	assert.Equal(t, []string{gids[0], gids[0], gids[0], gids[0], gids[0], gids[0]}, gids)
+
+	// 已有的全局事务不会被替换
+	gids = nil
+	require.NoError(t, tcc.Try(WithTransactionID(context.Background(), "gid-1"), nil))
+	assert.Equal(t, []string{"gid-1", "gid-1", "gid-1"}, gids)
+}
+
+func TestTransactionPerTask(t *testing.T) {
+	var tries, confirms int32
+	store := NewMemoryBarrierStore()
+	task := NewTCCTask(NewTCC(failingTask(0, &tries), failingTask(0, &confirms), NewFunc(UI),
+		WithBarrier(store))).Strict()
+
+	// 每次执行是一个新的全局事务，屏障不会跳过之后的 Try
+	require.NoError(t, task.Execute(context.Background(), nil))
+	require.NoError(t, task.Execute(context.Background(), nil))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&tries))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&confirms))
+}
+
+func TestTransactionHeader(t *testing.T) {
+	var joined string
+	server := httptest.NewServer(TransactionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		joined = TransactionID(r.Context())
+	})))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, nil)
+	require.NoError(t, err)
+	InjectTransaction(WithTransactionID(context.Background(), "gid-1"), req.Header)
+	assert.Equal(t, "gid-1", req.Header.Get(TransactionHeader))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "gid-1", joined)
+
+	header := http.Header{}
+	InjectTransaction(context.Background(), header)
+	assert.Empty(t, header.Get(TransactionHeader))
+	assert.Empty(t, TransactionID(ExtractTransaction(context.Background(), header)))
+}
+
+func TestTransactionPerTaskInRegistry(t *testing.T) {
+	var tries int32
+	task := NewTCCTask(NewTCC(failingTask(0, &tries), NewFunc(UI), NewFunc(UI),
+		WithBarrier(NewMemoryBarrierStore()))).Strict()
+	var iterations int
+	loop := NewWhileTask(func(context.Context, interface{}) bool {
+		iterations++
+		return iterations <= 3
+	}).WithTask(task)
+
+	// 执行的 id 不是全局事务，每次执行仍然使用新的全局事务
+	require.NoError(t, NewRegistry().Execute(context.Background(), "exec-1", loop, nil))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&tries))
+}
@@ -0,0 +1,348 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/multierr"
+)
+
+const defaultVisibility = 5 * time.Minute
+
+type workerOptions struct {
+	visibility  time.Duration
+	concurrency int
+	onError     func(msg *Message, err error)
+}
+
+type WorkerOption interface {
+	apply(*workerOptions)
+}
+
+type visibilityWorkerOption struct {
+	visibility time.Duration
+}
+
+func (v visibilityWorkerOption) apply(opts *workerOptions) {
+	opts.visibility = v.visibility
+}
+
+// WithVisibility set how long a dequeued message stays invisible before it is redelivered,
+// a non-positive visibility keeps the default of 5 minutes
+func WithVisibility(visibility time.Duration) WorkerOption {
+	return visibilityWorkerOption{visibility}
+}
+
+type concurrencyWorkerOption struct {
+	concurrency int
+}
+
+func (c concurrencyWorkerOption) apply(opts *workerOptions) {
+	opts.concurrency = c.concurrency
+}
+
+// WithConcurrency set how many messages a worker runs at the same time
+func WithConcurrency(concurrency int) WorkerOption {
+	return concurrencyWorkerOption{concurrency}
+}
+
+type errorHandlerWorkerOption struct {
+	f func(msg *Message, err error)
+}
+
+func (e errorHandlerWorkerOption) apply(opts *workerOptions) {
+	opts.onError = e.f
+}
+
+// WithErrorHandler is called with the errors of a worker that keeps running, msg is nil when
+// dequeuing failed. The errors are logged by default.
+func WithErrorHandler(f func(msg *Message, err error)) WorkerOption {
+	return errorHandlerWorkerOption{f}
+}
+
+func logWorkerError(msg *Message, err error) {
+	if msg == nil {
+		log.Printf("workflow: dequeue: %v", err)
+		return
+	}
+	log.Printf("workflow: message %s of task %q: %v", msg.ID, msg.Task, err)
+}
+
+func newWorkerOptions(opts []WorkerOption) *workerOptions {
+	opt := &workerOptions{
+		visibility:  defaultVisibility,
+		concurrency: 1,
+		onError:     logWorkerError,
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.concurrency < 1 {
+		opt.concurrency = 1
+	}
+	if opt.visibility <= 0 {
+		opt.visibility = defaultVisibility
+	}
+	return opt
+}
+
+// NewWorker returns a worker that pulls task runs from the named queue.
+// The registered task receives the run input as json.RawMessage.
+func NewWorker(q Queue, name string, opts ...WorkerOption) *worker {
+	opt := newWorkerOptions(opts)
+	return &worker{
+		queue:       q,
+		name:        name,
+		visibility:  opt.visibility,
+		concurrency: opt.concurrency,
+		onError:     opt.onError,
+		tasks:       make(map[string]Task),
+	}
+}
+
+type worker struct {
+	queue       Queue
+	name        string
+	visibility  time.Duration
+	concurrency int
+	onError     func(msg *Message, err error)
+	mutex       sync.RWMutex
+	tasks       map[string]Task
+}
+
+func (w *worker) Register(name string, t Task) {
+	w.mutex.Lock()
+	w.tasks[name] = t
+	w.mutex.Unlock()
+}
+
+// Run executes task runs until ctx is done, the errors of the queue are passed to the error handler
+// and do not stop the worker
+func (w *worker) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (w *worker) loop(ctx context.Context) {
+	for {
+		msg, err := w.queue.Dequeue(ctx, w.name, w.visibility)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			w.onError(nil, err)
+			// 队列不可用时稍后重试
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(defaultPollInterval):
+			}
+			continue
+		}
+		if err = w.handle(ctx, msg); err != nil && ctx.Err() == nil {
+			// 未确认的消息会在可见性超时后重新投递
+			w.onError(msg, err)
+		}
+	}
+}
+
+// extend keeps msg invisible while it runs, until stop is closed
+func (w *worker) extend(ctx context.Context, msg *Message, stop <-chan struct{}) {
+	ticker := time.NewTicker(w.visibility / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := w.queue.Extend(ctx, w.name, msg.ID, w.visibility); err != nil {
+				w.onError(msg, err)
+				if errors.Is(err, ErrNotInflight) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *worker) handle(ctx context.Context, msg *Message) error {
+	w.mutex.RLock()
+	t, ok := w.tasks[msg.Task]
+	w.mutex.RUnlock()
+	var err error
+	if ok {
+		stop := make(chan struct{})
+		go w.extend(ctx, msg, stop)
+		err = t.Execute(ctx, msg.Input)
+		close(stop)
+	} else {
+		err = fmt.Errorf("task %q is not registered", msg.Task)
+	}
+	if msg.ReplyTo != "" {
+		reply := &Message{ID: msg.ID, Task: msg.Task, Attempt: msg.Attempt}
+		if err != nil {
+			reply.Error = err.Error()
+		}
+		if err = w.queue.Enqueue(ctx, msg.ReplyTo, reply); err != nil {
+			// 未确认的消息会在可见性超时后重新投递
+			return err
+		}
+	}
+	return w.queue.Ack(ctx, w.name, msg.ID)
+}
+
+// NewQueueExecutor returns an executor that enqueues task runs onto the named queue
+// and waits for the workers' results on its own reply queue
+func NewQueueExecutor(q Queue, name string, opts ...WorkerOption) *queueExecutor {
+	opt := newWorkerOptions(opts)
+	return &queueExecutor{
+		queue:      q,
+		name:       name,
+		replyTo:    fmt.Sprintf("%s-reply-%s", name, uuid.NewV4().String()),
+		visibility: opt.visibility,
+		onError:    opt.onError,
+		pending:    make(map[string]chan *Message),
+	}
+}
+
+type queueExecutor struct {
+	queue      Queue
+	name       string
+	replyTo    string
+	visibility time.Duration
+	onError    func(msg *Message, err error)
+	mutex      sync.Mutex
+	pending    map[string]chan *Message
+}
+
+// Run receives the results of the enqueued task runs until ctx is done,
+// the errors of the queue are passed to the error handler
+func (e *queueExecutor) Run(ctx context.Context) error {
+	for {
+		msg, err := e.queue.Dequeue(ctx, e.replyTo, e.visibility)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			e.onError(nil, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(defaultPollInterval):
+			}
+			continue
+		}
+		e.mutex.Lock()
+		ch, ok := e.pending[msg.ID]
+		delete(e.pending, msg.ID)
+		e.mutex.Unlock()
+		if ok {
+			ch <- msg
+		}
+		if err = e.queue.Ack(ctx, e.replyTo, msg.ID); err != nil && ctx.Err() == nil {
+			e.onError(msg, err)
+		}
+	}
+}
+
+// Task returns a Task that runs the task registered as name on a worker
+func (e *queueExecutor) Task(name string, opts ...Option) Task {
+	opt := &options{
+		info: DefaultTaskInfo(""),
+	}
+	for _, o := range opts {
+		o.apply(opt)
+	}
+	if opt.hub != nil {
+		opt.info = opt.hub.Watch(opt.info)
+	}
+	opt.annotate()
+	// 初始化状态
+	if opt.info.Name() == "" {
+		opt.info.SetName(name)
+	}
+	opt.info.SetState(Ready)
+	opt.info.SetDescription("queue task")
+	return &queueTask{
+		Info:      opt.info,
+		executor:  e,
+		task:      name,
+		callbacks: opt.callbacks,
+	}
+}
+
+type queueTask struct {
+	Info
+	executor  *queueExecutor
+	task      string
+	callbacks []Callback
+}
+
+func (q *queueTask) Execute(ctx context.Context, input interface{}, callbacks ...Callback) error {
+	ctx, info := startRun(ctx, q)
+	info.SetState(Running)
+	ctx, run, replayed, err := beginRun(ctx, q)
+	if !replayed && err == nil {
+		err = q.executor.execute(ctx, q.task, input)
+	}
+	err = multierr.Append(err, run.end(ctx, err))
+	info.AddError(err)
+	markCancelled(ctx, info)
+	for _, callback := range q.callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	for _, callback := range callbacks {
+		callback.Trigger(ctx, info, input, err)
+	}
+	return err
+}
+
+func (e *queueExecutor) execute(ctx context.Context, task string, input interface{}) error {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+	msg := &Message{
+		ID:      uuid.NewV4().String(),
+		Task:    task,
+		Input:   data,
+		ReplyTo: e.replyTo,
+	}
+	ch := make(chan *Message, 1)
+	e.mutex.Lock()
+	e.pending[msg.ID] = ch
+	e.mutex.Unlock()
+	defer func() {
+		e.mutex.Lock()
+		delete(e.pending, msg.ID)
+		e.mutex.Unlock()
+	}()
+	if err = e.queue.Enqueue(ctx, e.name, msg); err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case reply := <-ch:
+		if reply.Error != "" {
+			return errors.New(reply.Error)
+		}
+		return nil
+	}
+}
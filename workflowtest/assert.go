@@ -0,0 +1,112 @@
+package workflowtest
+
+import (
+	"sync"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/crochee/workflow"
+)
+
+const recorderBuffer = 1 << 14
+
+// StateRecorder records the state sequence of the infos watched by its event hub. The hub drops
+// a subscriber that falls behind, the recorder then reports it (see Overflowed and AssertStates).
+type StateRecorder struct {
+	hub        *workflow.EventHub
+	sub        *workflow.Subscription
+	mutex      sync.Mutex
+	states     map[string][]workflow.State
+	closed     bool
+	overflowed bool
+}
+
+func NewStateRecorder() *StateRecorder {
+	return newStateRecorder(recorderBuffer)
+}
+
+func newStateRecorder(size int) *StateRecorder {
+	hub := workflow.NewEventHub(size)
+	return &StateRecorder{
+		hub:    hub,
+		sub:    hub.Subscribe("", 0),
+		states: make(map[string][]workflow.State),
+	}
+}
+
+// Option watches the info of a task or TCC
+func (r *StateRecorder) Option() workflow.Option {
+	return workflow.WithEventHub(r.hub)
+}
+
+func (r *StateRecorder) Hub() *workflow.EventHub {
+	return r.hub
+}
+
+// States returns every state set on an info, or on all its runs for the id of a definition.
+// A state set again, such as Running for a retry, is recorded again.
+func (r *StateRecorder) States(id string) []workflow.State {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// 事件在发布时已同步写入通道，取出已有的即可
+	for drained := false; !drained; {
+		select {
+		case e, ok := <-r.sub.Events():
+			if !ok {
+				// 未关闭的订阅被事件中心断开，说明有事件丢失
+				r.overflowed = r.overflowed || !r.closed
+				drained = true
+				break
+			}
+			if e.Type == workflow.EventState {
+				r.record(e.InfoID, e.State)
+				if e.DefinitionID != "" {
+					r.record(e.DefinitionID, e.State)
+				}
+			}
+		default:
+			drained = true
+		}
+	}
+	return append([]workflow.State(nil), r.states[id]...)
+}
+
+func (r *StateRecorder) record(id string, state workflow.State) {
+	r.states[id] = append(r.states[id], state)
+}
+
+// Overflowed reports whether events were lost because more were published than the recorder buffers
+// between two calls of States
+func (r *StateRecorder) Overflowed() bool {
+	r.States("")
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.overflowed
+}
+
+func (r *StateRecorder) Close() {
+	r.mutex.Lock()
+	r.closed = true
+	r.mutex.Unlock()
+	r.sub.Close()
+}
+
+// AssertStates asserts the state sequence recorded for id, it fails when the recorder lost events
+func AssertStates(t assert.TestingT, r *StateRecorder, id string, expected ...workflow.State) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	states := r.States(id)
+	if r.Overflowed() {
+		return assert.Fail(t, "state recorder overflowed, events were lost", "states of %s", id)
+	}
+	return assert.Equal(t, expected, states, "states of %s", id)
+}
+
+// AssertState asserts the state of info, the error of the info is reported when it differs
+func AssertState(t assert.TestingT, info workflow.Info, expected workflow.State) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+	return assert.Equal(t, expected, info.State(), "state of %s %s, error: %v", info.Name(), info.ID(), info.Error())
+}
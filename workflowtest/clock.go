@@ -0,0 +1,148 @@
+// Package workflowtest provides a fake clock, recording tasks and assertions for testing workflows
+package workflowtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/crochee/workflow"
+)
+
+// tickBuffer is the number of ticks a ticker keeps for a slow consumer, unlike time.Ticker
+// which drops them, so that advancing the clock at once delivers every tick
+const tickBuffer = 1024
+
+// Clock is a fake workflow.Clock, its time only moves with Advance
+type Clock struct {
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*waiter
+}
+
+// NewClock returns a Clock set to now
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.cond = sync.NewCond(&c.mutex)
+	return c
+}
+
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *Clock) NewTimer(d time.Duration) workflow.Timer {
+	w := &waiter{clock: c, ch: make(chan time.Time, 1)}
+	w.Reset(d)
+	return w
+}
+
+func (c *Clock) NewTicker(d time.Duration) workflow.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	w := &waiter{clock: c, ch: make(chan time.Time, tickBuffer), period: d}
+	w.Reset(d)
+	return ticker{w}
+}
+
+// Advance moves the time forward by d and fires the timers and tickers due in the meantime, in order
+func (c *Clock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	target := c.now.Add(d)
+	for {
+		sort.Slice(c.waiters, func(i, j int) bool {
+			return c.waiters[i].deadline.Before(c.waiters[j].deadline)
+		})
+		if len(c.waiters) == 0 || c.waiters[0].deadline.After(target) {
+			break
+		}
+		w := c.waiters[0]
+		c.now = w.deadline
+		w.fire(c.now)
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			c.remove(w)
+		}
+	}
+	c.now = target
+	c.cond.Broadcast()
+}
+
+// BlockUntil waits until n timers or tickers are active,
+// it lets a test advance the time once the code under test is waiting
+func (c *Clock) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+func (c *Clock) add(w *waiter) {
+	c.waiters = append(c.waiters, w)
+	c.cond.Broadcast()
+}
+
+func (c *Clock) remove(w *waiter) bool {
+	for i, v := range c.waiters {
+		if v == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			c.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// waiter is a timer, or a ticker when period is set
+type waiter struct {
+	clock    *Clock
+	deadline time.Time
+	period   time.Duration
+	ch       chan time.Time
+}
+
+func (w *waiter) fire(now time.Time) {
+	select {
+	case w.ch <- now:
+	default:
+		// 缓冲区已满时丢弃
+	}
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *waiter) Stop() bool {
+	w.clock.mutex.Lock()
+	defer w.clock.mutex.Unlock()
+	return w.clock.remove(w)
+}
+
+func (w *waiter) Reset(d time.Duration) bool {
+	w.clock.mutex.Lock()
+	defer w.clock.mutex.Unlock()
+	active := w.clock.remove(w)
+	w.deadline = w.clock.now.Add(d)
+	if d <= 0 && w.period == 0 {
+		w.fire(w.clock.now)
+		return active
+	}
+	w.clock.add(w)
+	return active
+}
+
+type ticker struct {
+	*waiter
+}
+
+func (t ticker) Stop() {
+	t.waiter.Stop()
+}
@@ -0,0 +1,122 @@
+package workflowtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/crochee/workflow"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestClock(t *testing.T) {
+	clock := NewClock(epoch)
+	timer := clock.NewTimer(time.Second)
+	ticker := clock.NewTicker(400 * time.Millisecond)
+	defer ticker.Stop()
+
+	clock.Advance(999 * time.Millisecond)
+	assert.Equal(t, epoch.Add(400*time.Millisecond), <-ticker.C())
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	assert.Equal(t, epoch.Add(time.Second), <-timer.C())
+	assert.False(t, timer.Stop())
+	assert.Equal(t, epoch.Add(time.Second), clock.Now())
+}
+
+func TestRetryTaskWithClock(t *testing.T) {
+	clock := NewClock(epoch)
+	task := NewTask("flaky").WithClock(clock).Returns(errors.New("1"), errors.New("2"), nil)
+	retry := workflow.RetryTask(task, workflow.WithAttempt(3), workflow.WithInterval(time.Minute),
+		workflow.WithRetryClock(clock))
+
+	done := make(chan error)
+	go func() { done <- retry.Execute(context.Background(), "input") }()
+	// 第二次尝试立即执行，第三次等待退避的定时器
+	clock.BlockUntil(1)
+	assert.Equal(t, 2, task.CallCount())
+	clock.Advance(2 * time.Minute)
+	assert.NoError(t, <-done)
+	calls := task.Calls()
+	assert.Len(t, calls, 3)
+	assert.True(t, calls[2].Time.Sub(calls[1].Time) >= time.Minute)
+	assert.Equal(t, []interface{}{"input", "input", "input"}, task.Inputs())
+}
+
+func TestTimeWheelWithClock(t *testing.T) {
+	clock := NewClock(epoch)
+	fired := make(chan workflow.Info, 1)
+	tw := workflow.NewTimeWheel(time.Second, 60, workflow.WithTimeWheelClock(clock),
+		workflow.WithTimerCallbacks(callback(func(info workflow.Info) { fired <- info })))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tw.Start(ctx)
+
+	task := NewTask("expire")
+	tw.AddTimer(workflow.DelayTask{Delay: 5 * time.Second, Task: task})
+	clock.BlockUntil(1)
+	clock.Advance(4 * time.Second)
+	assert.Never(t, func() bool { return len(fired) > 0 }, 50*time.Millisecond, time.Millisecond)
+	clock.Advance(time.Second)
+	select {
+	case info := <-fired:
+		assert.Equal(t, task.ID(), info.ID())
+	case <-time.After(time.Second):
+		t.Fatal("timer did not fire")
+	}
+}
+
+type callback func(info workflow.Info)
+
+func (c callback) Trigger(_ context.Context, info workflow.Info, _ interface{}, _ error) {
+	c(info)
+}
+
+func TestExpiryWithClock(t *testing.T) {
+	clock := NewClock(epoch)
+	tw := workflow.NewTimeWheel(time.Second, 60, workflow.WithTimeWheelClock(clock))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tw.Start(ctx)
+
+	confirm, cancelTask := NewTask("confirm"), NewTask("cancel")
+	tcc := workflow.NewTCC(NewTask("try"), confirm, cancelTask, workflow.WithExpiry(tw, time.Minute))
+	txCtx := workflow.WithTransactionID(context.Background(), "gid-1")
+	assert.NoError(t, tcc.Try(txCtx, nil))
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Second)
+	assert.Never(t, func() bool { return cancelTask.CallCount() > 0 }, 50*time.Millisecond, time.Millisecond)
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool { return cancelTask.CallCount() == 1 }, time.Second, time.Millisecond)
+
+	// 过期之后很久到达的 Confirm 仍被拒绝
+	clock.Advance(time.Hour)
+	assert.ErrorIs(t, tcc.Confirm(txCtx, nil), workflow.ErrTCCExpired)
+	assert.Equal(t, 0, confirm.CallCount())
+}
+
+func TestLoopTaskWithClock(t *testing.T) {
+	clock := NewClock(epoch)
+	task := NewTask("poll").WithClock(clock)
+	loop := workflow.NewUntilTask(func(context.Context, interface{}) bool { return task.CallCount() == 2 }).
+		WithDelay(time.Minute).WithClock(clock).WithTask(task)
+
+	done := make(chan error)
+	go func() { done <- loop.Execute(context.Background(), nil) }()
+	// 第二次迭代等待延迟的定时器
+	clock.BlockUntil(1)
+	assert.Equal(t, 1, task.CallCount())
+	clock.Advance(time.Minute)
+	assert.NoError(t, <-done)
+	calls := task.Calls()
+	assert.Len(t, calls, 2)
+	assert.Equal(t, time.Minute, calls[1].Time.Sub(calls[0].Time))
+}
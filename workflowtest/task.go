@@ -0,0 +1,162 @@
+package workflowtest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/crochee/workflow"
+)
+
+// Call is a recorded call of a Task
+type Call struct {
+	Name  string      `json:"name"`
+	Input interface{} `json:"input"`
+	Time  time.Time   `json:"time"`
+	task  *Task
+}
+
+// calls is the log of the calls of one or more tasks, in call order
+type calls struct {
+	mutex sync.Mutex
+	calls []Call
+	now   func() time.Time
+}
+
+func (c *calls) append(call Call) {
+	c.mutex.Lock()
+	call.Time = c.now()
+	c.calls = append(c.calls, call)
+	c.mutex.Unlock()
+}
+
+func (c *calls) of(task *Task) []Call {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	result := make([]Call, 0, len(c.calls))
+	for _, call := range c.calls {
+		if task == nil || call.task == task {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+// Task is a workflow.Task recording its calls, it succeeds unless told otherwise by Returns or Do
+type Task struct {
+	workflow.Task
+	log     *calls
+	mutex   sync.Mutex
+	errs    []error
+	handler func(ctx context.Context, input interface{}) error
+}
+
+// NewTask returns a Task named name
+func NewTask(name string, opts ...workflow.Option) *Task {
+	return newTask(name, &calls{now: time.Now}, opts)
+}
+
+func newTask(name string, log *calls, opts []workflow.Option) *Task {
+	t := &Task{log: log}
+	t.Task = workflow.NewFunc(t.call, opts...)
+	t.Task.SetName(name)
+	return t
+}
+
+func (t *Task) call(ctx context.Context, input interface{}) error {
+	t.log.append(Call{Name: t.Name(), Input: input, task: t})
+	t.mutex.Lock()
+	handler := t.handler
+	var err error
+	if len(t.errs) > 0 {
+		err = t.errs[0]
+		// 最后一个错误一直返回
+		if len(t.errs) > 1 {
+			t.errs = t.errs[1:]
+		}
+	}
+	t.mutex.Unlock()
+	if err == nil && handler != nil {
+		err = handler(ctx, input)
+	}
+	return err
+}
+
+// Returns makes the successive calls return errs, the last one is returned by the remaining calls
+func (t *Task) Returns(errs ...error) *Task {
+	t.mutex.Lock()
+	t.errs = errs
+	t.mutex.Unlock()
+	return t
+}
+
+// Do runs f on the calls that do not return an error set by Returns
+func (t *Task) Do(f func(ctx context.Context, input interface{}) error) *Task {
+	t.mutex.Lock()
+	t.handler = f
+	t.mutex.Unlock()
+	return t
+}
+
+// WithClock stamps the calls with the time of clock
+func (t *Task) WithClock(clock workflow.Clock) *Task {
+	t.log.mutex.Lock()
+	t.log.now = clock.Now
+	t.log.mutex.Unlock()
+	return t
+}
+
+func (t *Task) Calls() []Call {
+	return t.log.of(t)
+}
+
+func (t *Task) Inputs() []interface{} {
+	calls := t.Calls()
+	inputs := make([]interface{}, 0, len(calls))
+	for _, call := range calls {
+		inputs = append(inputs, call.Input)
+	}
+	return inputs
+}
+
+func (t *Task) CallCount() int {
+	return len(t.Calls())
+}
+
+// TCC is a workflow.TCC whose phases are recording tasks named try, confirm and cancel
+type TCC struct {
+	workflow.TCC
+	TryTask     *Task
+	ConfirmTask *Task
+	CancelTask  *Task
+	log         *calls
+}
+
+// NewTCC returns a TCC named name, opts apply to the TCC
+func NewTCC(name string, opts ...workflow.Option) *TCC {
+	log := &calls{now: time.Now}
+	t := &TCC{
+		TryTask:     newTask("try", log, nil),
+		ConfirmTask: newTask("confirm", log, nil),
+		CancelTask:  newTask("cancel", log, nil),
+		log:         log,
+	}
+	t.TCC = workflow.NewTCC(t.TryTask, t.ConfirmTask, t.CancelTask, opts...)
+	t.TCC.SetName(name)
+	return t
+}
+
+// Calls returns the calls of every phase, in call order
+func (t *TCC) Calls() []Call {
+	return t.log.of(nil)
+}
+
+// Phases returns the names of the phases called, in call order
+func (t *TCC) Phases() []string {
+	calls := t.Calls()
+	phases := make([]string, 0, len(calls))
+	for _, call := range calls {
+		phases = append(phases, call.Name)
+	}
+	return phases
+}
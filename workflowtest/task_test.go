@@ -0,0 +1,96 @@
+package workflowtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/crochee/workflow"
+)
+
+func TestTCCRecorder(t *testing.T) {
+	recorder := NewStateRecorder()
+	defer recorder.Close()
+	paid := NewTCC("pay", recorder.Option())
+	shipped := NewTCC("ship", recorder.Option())
+	shipped.TryTask.Returns(errors.New("out of stock"))
+	pipeline := workflow.NewTCCPipeline().WithTCCs(paid, shipped)
+
+	ctx := context.Background()
+	assert.EqualError(t, pipeline.Try(ctx, "order-1"), "out of stock")
+	_ = pipeline.Cancel(ctx, "order-1")
+
+	assert.Equal(t, []string{"try", "cancel"}, paid.Phases())
+	assert.Equal(t, []string{"try", "cancel"}, shipped.Phases())
+	assert.Equal(t, []interface{}{"order-1"}, paid.CancelTask.Inputs())
+	assert.Equal(t, 0, paid.ConfirmTask.CallCount())
+	AssertStates(t, recorder, paid.ID(), workflow.Ready, workflow.Trying, workflow.Cancelling, workflow.Cancelled)
+	AssertState(t, paid, workflow.Cancelled)
+	assert.EqualError(t, shipped.Error(), "out of stock")
+}
+
+func TestTaskRecorder(t *testing.T) {
+	recorder := NewStateRecorder()
+	defer recorder.Close()
+	task := NewTask("step", recorder.Option()).Do(func(ctx context.Context, input interface{}) error {
+		if input == nil {
+			return errors.New("no input")
+		}
+		return nil
+	})
+
+	run, err := workflow.Run(context.Background(), task, 1)
+	assert.NoError(t, err)
+	_, err = workflow.Run(context.Background(), task, nil)
+	assert.EqualError(t, err, "no input")
+
+	AssertStates(t, recorder, run.ID(), workflow.Running, workflow.Success)
+	// 定义的状态序列包含每次运行
+	AssertStates(t, recorder, task.ID(), workflow.Ready, workflow.Running, workflow.Success,
+		workflow.Running, workflow.Error)
+	assert.Equal(t, []interface{}{1, nil}, task.Inputs())
+}
+
+func TestRecorderRepeatedStates(t *testing.T) {
+	recorder := NewStateRecorder()
+	defer recorder.Close()
+	paid := NewTCC("pay", recorder.Option())
+
+	ctx := context.Background()
+	assert.NoError(t, paid.Try(ctx, "order-1"))
+	assert.NoError(t, paid.Try(ctx, "order-1"))
+	// 重复的状态同样记录
+	AssertStates(t, recorder, paid.ID(), workflow.Ready, workflow.Trying, workflow.Trying)
+}
+
+func TestRecorderOverflow(t *testing.T) {
+	recorder := newStateRecorder(4)
+	defer recorder.Close()
+	task := NewTask("step", recorder.Option())
+	for i := 0; i < 3; i++ {
+		_, err := workflow.Run(context.Background(), task, i)
+		assert.NoError(t, err)
+	}
+	// 丢失事件时断言失败，而不是比较不完整的序列
+	assert.True(t, recorder.Overflowed())
+	var failed failingT
+	assert.False(t, AssertStates(&failed, recorder, task.ID()))
+	assert.NotEmpty(t, failed.errors)
+
+	recorder = NewStateRecorder()
+	defer recorder.Close()
+	AssertStates(t, recorder, "missing")
+	assert.False(t, recorder.Overflowed())
+}
+
+// failingT records the failures of the assertions run on it
+type failingT struct {
+	errors []string
+}
+
+func (f *failingT) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}